@@ -0,0 +1,241 @@
+package loadbalance
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alphadose/haxmap"
+)
+
+// aliasTable is the immutable table of Vose's alias method.
+// Column `i` is kept with the probability `prob[i] / weightSum`,
+// otherwise the column goes to `alias[i]`.
+type aliasTable[T Hashable, I Instance[T]] struct {
+	instances []I
+	weights   []int
+	prob      []uint64
+	alias     []int
+	weightSum uint64
+}
+
+// newAliasTable builds the table in O(n) by Vose's alias method,
+// the weights are scaled by the number of instance so integers are exact.
+func newAliasTable[T Hashable, I Instance[T]](instances []I, weights []int) *aliasTable[T, I] {
+	n := len(instances)
+	t := &aliasTable[T, I]{
+		instances: instances,
+		weights:   weights,
+		prob:      make([]uint64, n),
+		alias:     make([]int, n),
+	}
+	for _, w := range weights {
+		t.weightSum += uint64(w)
+	}
+	scaled := make([]uint64, n)
+	small := make([]int, 0, n)
+	large := make([]int, 0, n)
+	for i, w := range weights {
+		scaled[i] = uint64(w) * uint64(n)
+		if scaled[i] < t.weightSum {
+			small = append(small, i)
+		} else {
+			large = append(large, i)
+		}
+	}
+	for len(small) > 0 && len(large) > 0 {
+		l, g := small[len(small)-1], large[len(large)-1]
+		small, large = small[:len(small)-1], large[:len(large)-1]
+		t.prob[l] = scaled[l]
+		t.alias[l] = g
+		scaled[g] = scaled[g] + scaled[l] - t.weightSum
+		if scaled[g] < t.weightSum {
+			small = append(small, g)
+		} else {
+			large = append(large, g)
+		}
+	}
+	for _, i := range large {
+		t.prob[i] = t.weightSum
+	}
+	for _, i := range small {
+		t.prob[i] = t.weightSum
+	}
+	return t
+}
+
+// AliasRandom is the weighted random load-balance by Vose's alias method.
+// Unlike `WeightedRandom` that scans the instances on every select,
+// select draws a column of the alias table and a coin, so it is O(1)
+// whatever the number of instance is.
+//
+// The table is rebuilt in O(n) on `Add` and `Del` and swapped in atomically,
+// select never takes a lock.
+// The weight of a instance is read when the table is rebuilt.
+type AliasRandom[T Hashable, I Instance[T]] struct {
+	mutex     sync.Mutex
+	hashmap   *haxmap.Map[T, I]
+	instances []I
+	table     atomic.Pointer[aliasTable[T, I]]
+	random    XorShift64
+}
+
+func NewAliasRandom[T Hashable, I Instance[T]]() *AliasRandom[T, I] {
+	ar := &AliasRandom[T, I]{
+		hashmap:   haxmap.New[T, I](8),
+		instances: make([]I, 0, 8),
+		random:    NewXorShift64(uint64(time.Now().UnixNano())),
+	}
+	ar.rebuild()
+	return ar
+}
+
+// rebuild the alias table from the current instances,
+// the caller must hold the mutex.
+func (ar *AliasRandom[T, I]) rebuild() {
+	instances := make([]I, len(ar.instances))
+	copy(instances, ar.instances)
+	weights := make([]int, len(instances))
+	for i, instance := range instances {
+		weights[i] = instanceWeight[T](instance)
+	}
+	ar.table.Store(newAliasTable[T](instances, weights))
+}
+
+// Add some instances and return the number of successful operation
+func (ar *AliasRandom[T, I]) Add(instances ...I) int {
+	ar.mutex.Lock()
+	defer ar.mutex.Unlock()
+	count := 0
+	for _, instance := range instances {
+		if _, ok := ar.hashmap.GetOrSet(instance.InstanceID(), instance); !ok {
+			ar.instances = append(ar.instances, instance)
+			count++
+		}
+	}
+	if count > 0 {
+		ar.rebuild()
+	}
+	return count
+}
+
+// Del some instances and return the number of successful operation
+func (ar *AliasRandom[T, I]) Del(instances ...I) int {
+	ar.mutex.Lock()
+	defer ar.mutex.Unlock()
+	count := 0
+	for _, instance := range instances {
+		id := instance.InstanceID()
+		if _, ok := ar.hashmap.Get(id); ok {
+			for i := 0; i < len(ar.instances); i++ {
+				if ar.instances[i].InstanceID() == id {
+					ar.hashmap.Del(id)
+					ar.instances = append(ar.instances[:i], ar.instances[i+1:]...)
+					break
+				}
+			}
+			count++
+		}
+	}
+	if count > 0 {
+		ar.rebuild()
+	}
+	return count
+}
+
+// Replace the instances with the new set in one step
+// and return the number of instances added or deleted,
+// the table is rebuilt once with the new weights.
+func (ar *AliasRandom[T, I]) Replace(instances ...I) int {
+	ar.mutex.Lock()
+	defer ar.mutex.Unlock()
+	var count int
+	ar.instances, count = replaceInstances(ar.hashmap, ar.instances, instances)
+	ar.rebuild()
+	return count
+}
+
+// Get the value corresponding to the key
+func (ar *AliasRandom[T, I]) Get(key T) (I, bool) {
+	return haxMapGetVal(ar.hashmap, key)
+}
+
+// ForEach every instances. it is concurrency safe.
+func (ar *AliasRandom[T, I]) ForEach(callback func(T, I) bool) {
+	haxMapForEach(ar.hashmap, callback)
+}
+
+func (ar *AliasRandom[T, I]) Size() int {
+	return int(ar.hashmap.Len())
+}
+
+// Select a instance
+func (ar *AliasRandom[T, I]) Select() (ins I) {
+	t := ar.table.Load()
+	n := uint64(len(t.instances))
+	if n == 0 {
+		return
+	}
+	i := ar.random.Uint64() % n
+	if ar.random.Uint64()%t.weightSum < t.prob[i] {
+		return t.instances[i]
+	}
+	return t.instances[t.alias[i]]
+}
+
+// Pick selects a instance like `Select`, or returns `ErrNoInstances` if there is none
+func (ar *AliasRandom[T, I]) Pick(ctx context.Context) (I, error) {
+	return pick(ctx, func() (I, bool) { return ar.selectExcluding(nil) }, nil)
+}
+
+// SelectN selects at most n distinct instances at random by weight
+func (ar *AliasRandom[T, I]) SelectN(n int) []I {
+	return selectN[T, I](ar, n)
+}
+
+// SelectExcluding selects a instance at random by weight other than the excluded ones.
+// It draws again a few times when hitting a excluded instance,
+// then falls back to a O(n) scan over the rest of the instances.
+func (ar *AliasRandom[T, I]) SelectExcluding(exclude ...T) I {
+	ins, _ := ar.selectExcluding(exclude)
+	return ins
+}
+
+func (ar *AliasRandom[T, I]) selectExcluding(exclude []T) (ins I, ok bool) {
+	t := ar.table.Load()
+	n := uint64(len(t.instances))
+	if n == 0 {
+		return
+	}
+	for i := 0; i <= len(exclude); i++ {
+		c := ar.random.Uint64() % n
+		if ar.random.Uint64()%t.weightSum >= t.prob[c] {
+			c = uint64(t.alias[c])
+		}
+		if ins = t.instances[c]; !excluded(exclude, ins.InstanceID()) {
+			return ins, true
+		}
+	}
+	var sum uint64
+	for i, ins := range t.instances {
+		if !excluded(exclude, ins.InstanceID()) {
+			sum += uint64(t.weights[i])
+		}
+	}
+	if sum == 0 {
+		return *new(I), false
+	}
+	rdm := ar.random.Uint64() % sum
+	for i, ins := range t.instances {
+		if excluded(exclude, ins.InstanceID()) {
+			continue
+		}
+		w := uint64(t.weights[i])
+		if rdm < w {
+			return ins, true
+		}
+		rdm -= w
+	}
+	return *new(I), false
+}
@@ -0,0 +1,83 @@
+package loadbalance_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ydmxcz/loadbalance"
+)
+
+func TestAliasRandomDistribution(t *testing.T) {
+	lb := loadbalance.NewAliasRandom[string, *myService]()
+	if lb.Select() != nil {
+		t.Fatal("empty balancer selected a instance")
+	}
+	ins := getInstance(2)
+	lb.Add(ins...)
+
+	// the share of the instances of every weight
+	share := map[int]int{}
+	sum := 0
+	for _, in := range ins {
+		share[in.Memory] += in.Memory
+		sum += in.Memory
+	}
+	m := map[int]int{}
+	const total = 1000000
+	for i := 0; i < total; i++ {
+		m[lb.Select().Memory]++
+	}
+	for w, n := range m {
+		want := total * share[w] / sum
+		if n < want*95/100 || n > want*105/100 {
+			t.Fatalf("instances of weight %d got %d of %d, want about %d", w, n, total, want)
+		}
+	}
+
+	lb.Del(ins[:256]...)
+	for i := 0; i < 10000; i++ {
+		if lb.Select().Memory == 128 {
+			t.Fatal("selected a deleted instance")
+		}
+	}
+}
+
+func TestAliasRandomSelectExcluding(t *testing.T) {
+	lb := loadbalance.NewAliasRandom[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins...)
+
+	// the rest keep their weights 3:2 also after the draws hitting the excluded one
+	m := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		m[lb.SelectExcluding(ins[0].Address).Address]++
+	}
+	if m[ins[0].Address] != 0 || m[ins[1].Address] < 5700 || m[ins[1].Address] > 6300 {
+		t.Fatalf("got %v when %s is excluded", m, ins[0].Address)
+	}
+	if sel := lb.SelectExcluding(ins[0].Address, ins[1].Address, ins[2].Address); sel != nil {
+		t.Fatalf("got %s when all are excluded", sel.Address)
+	}
+}
+
+func TestAliasRandomSelectN(t *testing.T) {
+	checkLeftOut(t, loadbalance.NewAliasRandom[string, *myService]())
+}
+
+func TestAliasRandomReplace(t *testing.T) {
+	lb := loadbalance.NewAliasRandom[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins...)
+	updated := &myService{Address: ins[0].Address, Memory: 1}
+	if n := lb.Replace(updated, ins[2]); n != 1 || lb.Size() != 2 {
+		t.Fatalf("replaced %d instances, %d left", n, lb.Size())
+	}
+	m := map[string]int{}
+	for i := 0; i < 30000; i++ {
+		m[lb.Select().Address]++
+	}
+	if m[ins[1].Address] != 0 || math.Abs(float64(m[ins[0].Address])/30000-1.0/3) > 0.02 {
+		t.Fatalf("got %v after replace, want the weights 1,2", m)
+	}
+	checkReplaceAtomic(t, loadbalance.NewAliasRandom[string, *myService]())
+}
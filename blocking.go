@@ -0,0 +1,172 @@
+package loadbalance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultBlockingTimeout is the timeout of `Select` and `SelectBy` if `NewBlocking` was given none
+const defaultBlockingTimeout = time.Second
+
+// Blocking makes any load-balance wait for a instance when it is empty,
+// e.g. during startup before service discovery fills it,
+// instead of returning the zero value of I at once.
+//
+// `Pick` and `PickBy` wait until a instance is added or the context is done,
+// `Select` and `SelectBy` wait at most the timeout given to `NewBlocking`, 1 second by default,
+// so they never block for good, a unbounded wait is `Pick` with a context without deadline.
+// Instances are added to the Blocking instead of the target so that it can wake the waiters.
+// Only a empty target is waited for, `ErrAllUnhealthy` and other errors are returned at once.
+type Blocking[T Hashable, I Instance[T]] struct {
+	mutex   sync.Mutex
+	target  Balancer[T, I]
+	timeout time.Duration
+	// added is closed and replaced every time some instances are added
+	added chan struct{}
+}
+
+// NewBlocking returns a Blocking in front of the target, which can be any `Selector` or `SelectorBy`,
+// the optional argument is the timeout of `Select` and `SelectBy`.
+func NewBlocking[T Hashable, I Instance[T]](target Balancer[T, I], timeout ...time.Duration) *Blocking[T, I] {
+	b := &Blocking[T, I]{
+		target:  target,
+		timeout: defaultBlockingTimeout,
+		added:   make(chan struct{}),
+	}
+	if len(timeout) != 0 && timeout[0] > 0 {
+		b.timeout = timeout[0]
+	}
+	return b
+}
+
+// Add some instances to the target, wake the waiters
+// and return the number of successful operation
+func (b *Blocking[T, I]) Add(instances ...I) int {
+	count := b.target.Add(instances...)
+	if count > 0 {
+		b.wake()
+	}
+	return count
+}
+
+// Replace the instances of the target with the new set, wake the waiters
+// and return the number of instances added or deleted
+func (b *Blocking[T, I]) Replace(instances ...I) int {
+	count := Replace[T, I](b.target, instances...)
+	if count > 0 {
+		b.wake()
+	}
+	return count
+}
+
+// wake the waiters to try again
+func (b *Blocking[T, I]) wake() {
+	b.mutex.Lock()
+	close(b.added)
+	b.added = make(chan struct{})
+	b.mutex.Unlock()
+}
+
+// Del some instances from the target and return the number of successful operation
+func (b *Blocking[T, I]) Del(instances ...I) int {
+	return b.target.Del(instances...)
+}
+
+// Get the value corresponding to the key
+func (b *Blocking[T, I]) Get(key T) (I, bool) {
+	return b.target.Get(key)
+}
+
+// ForEach every instances of the target
+func (b *Blocking[T, I]) ForEach(callback func(T, I) bool) {
+	b.target.ForEach(callback)
+}
+
+func (b *Blocking[T, I]) Size() int {
+	return b.target.Size()
+}
+
+// wait calls `try` again every time some instances are added
+// until it finds a instance, fails with another error than `ErrNoInstances` or the context is done.
+func (b *Blocking[T, I]) wait(ctx context.Context, try func() (I, error)) (ins I, err error) {
+	for {
+		// take the channel before trying, so a instance added in between is not missed
+		b.mutex.Lock()
+		added := b.added
+		b.mutex.Unlock()
+		ins, err = try()
+		if !errors.Is(err, ErrNoInstances) {
+			return
+		}
+		select {
+		case <-added:
+		case <-ctx.Done():
+			return ins, ctx.Err()
+		}
+	}
+}
+
+// withTimeout returns the context of `Select` and `SelectBy`
+func (b *Blocking[T, I]) withTimeout() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), b.timeout)
+}
+
+// Pick selects a instance from the target, which must be a Selector,
+// and waits until one is added or the context is done when the target is empty.
+func (b *Blocking[T, I]) Pick(ctx context.Context) (I, error) {
+	s, ok := b.target.(Selector[T, I])
+	if !ok {
+		return *new(I), fmt.Errorf("loadbalance: %T is not a Selector", b.target)
+	}
+	return b.wait(ctx, func() (I, error) {
+		if p, ok := s.(Picker[T, I]); ok {
+			return p.Pick(ctx)
+		}
+		return pick(ctx, func() (I, bool) { return selectExcluding(s, nil) }, nil)
+	})
+}
+
+// PickBy selects a instance for the key from the target, which must be a SelectorBy,
+// and waits until one is added or the context is done when the target is empty.
+func (b *Blocking[T, I]) PickBy(ctx context.Context, key string) (I, error) {
+	s, ok := b.target.(SelectorBy[T, I])
+	if !ok {
+		return *new(I), fmt.Errorf("loadbalance: %T is not a SelectorBy", b.target)
+	}
+	return b.wait(ctx, func() (I, error) {
+		if p, ok := s.(PickerBy[T, I]); ok {
+			return p.PickBy(ctx, key)
+		}
+		return pick(ctx, func() (I, bool) { return selectByExcluding(s, key, nil) }, nil)
+	})
+}
+
+// Select a instance like `Pick` with the timeout given to `NewBlocking`,
+// it returns the zero value of I if no instance was added in time.
+func (b *Blocking[T, I]) Select() I {
+	ctx, cancel := b.withTimeout()
+	defer cancel()
+	ins, _ := b.Pick(ctx)
+	return ins
+}
+
+// SelectBy selects a instance for the key like `PickBy` with the timeout given to `NewBlocking`,
+// it returns the zero value of I if no instance was added in time.
+func (b *Blocking[T, I]) SelectBy(key string) I {
+	ctx, cancel := b.withTimeout()
+	defer cancel()
+	ins, _ := b.PickBy(ctx, key)
+	return ins
+}
+
+// selectExcluding never waits, so the other wrappers of the package
+// can select from a Blocking without blocking.
+func (b *Blocking[T, I]) selectExcluding(exclude []T) (ins I, ok bool) {
+	if s, ok := b.target.(Selector[T, I]); ok {
+		return selectExcluding(s, exclude)
+	}
+	return
+}
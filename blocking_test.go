@@ -0,0 +1,128 @@
+package loadbalance_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ydmxcz/loadbalance"
+)
+
+func TestBlockingPick(t *testing.T) {
+	b := loadbalance.NewBlocking[string, *myService](loadbalance.NewRoundRobin[string, *myService]())
+	ins := getInstance(1)
+
+	done := make(chan *myService)
+	go func() {
+		sel, err := b.Pick(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		done <- sel
+	}()
+	select {
+	case <-done:
+		t.Fatal("picked from a empty load-balance")
+	case <-time.After(20 * time.Millisecond):
+	}
+	b.Add(ins[0])
+	select {
+	case sel := <-done:
+		if sel != ins[0] {
+			t.Fatalf("picked %v, want %s", sel, ins[0].Address)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the waiter was not woken by Add")
+	}
+
+	b.Del(ins[0])
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := b.Pick(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestBlockingSelectTimeout(t *testing.T) {
+	b := loadbalance.NewBlocking[string, *myService](loadbalance.NewRandom[string, *myService](), 20*time.Millisecond)
+	start := time.Now()
+	if b.Select() != nil {
+		t.Fatal("selected from a empty load-balance")
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Fatal("Select returned before the timeout")
+	}
+	ins := getInstance(1)
+	b.Add(ins...)
+	if b.Select() == nil {
+		t.Fatal("selected nothing from a non-empty load-balance")
+	}
+}
+
+func TestBlockingSelectBy(t *testing.T) {
+	b := loadbalance.NewBlocking[string, loadbalance.Instance[string]](loadbalance.NewConsistentHash[string]())
+	ins := getInstance(1)
+	done := make(chan loadbalance.Instance[string])
+	go func() {
+		done <- b.SelectBy("key")
+	}()
+	time.Sleep(10 * time.Millisecond)
+	b.Add(ins[1])
+	select {
+	case sel := <-done:
+		if sel != loadbalance.Instance[string](ins[1]) {
+			t.Fatalf("selected %v, want %s", sel, ins[1].Address)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the waiter was not woken by Add")
+	}
+	if _, err := b.Pick(context.Background()); err == nil {
+		t.Fatal("Pick on a SelectorBy succeeded")
+	}
+}
+
+func TestBlockingAllUnhealthy(t *testing.T) {
+	cb := loadbalance.NewCircuitBreaker[string, *myService](loadbalance.NewRoundRobin[string, *myService](),
+		loadbalance.BreakerConfig{FailureThreshold: 1})
+	b := loadbalance.NewBlocking[string, *myService](cb)
+	ins := getInstance(1)
+	b.Add(ins[0])
+	cb.Report(ins[0], errors.New("failed"))
+	// a instance is there, it is not worth waiting for
+	if _, err := b.Pick(context.Background()); !errors.Is(err, loadbalance.ErrAllUnhealthy) {
+		t.Fatalf("got %v, want ErrAllUnhealthy", err)
+	}
+}
+
+// Select never blocks for good without a timeout given
+func TestBlockingSelectDefaultTimeout(t *testing.T) {
+	b := loadbalance.NewBlocking[string, *myService](loadbalance.NewRoundRobin[string, *myService]())
+	done := make(chan *myService)
+	go func() {
+		done <- b.Select()
+	}()
+	select {
+	case sel := <-done:
+		if sel != nil {
+			t.Fatalf("selected %s from a empty load-balance", sel.Address)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Select is still waiting")
+	}
+}
+
+func TestBlockingReplace(t *testing.T) {
+	b := loadbalance.NewBlocking[string, *myService](loadbalance.NewRoundRobin[string, *myService]())
+	ins := getInstance(1)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		b.Replace(ins[1])
+	}()
+	// the waiters are woken by a replace as well
+	sel, err := b.Pick(context.Background())
+	if err != nil || sel != ins[1] {
+		t.Fatalf("picked %v, %v", sel, err)
+	}
+	checkReplaceAtomic(t, loadbalance.NewBlocking[string, *myService](loadbalance.NewRoundRobin[string, *myService]()))
+}
@@ -0,0 +1,295 @@
+package loadbalance
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of the circuit breaker of a instance
+type BreakerState int
+
+const (
+	// BreakerClosed lets all requests through
+	BreakerClosed BreakerState = iota
+	// BreakerOpen rejects all requests until the open timeout is over
+	BreakerOpen
+	// BreakerHalfOpen lets a limited number of trial requests through
+	BreakerHalfOpen
+)
+
+func (bs BreakerState) String() string {
+	switch bs {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerConfig is the config of CircuitBreaker,
+// the zero value of a field means its default value.
+type BreakerConfig struct {
+	// FailureThreshold opens the breaker after this number of consecutive failures, 5 by default
+	FailureThreshold int
+	// OpenTimeout is the time a breaker stays open before it becomes half-open, 30 seconds by default
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of trial requests let through when half-open,
+	// the breaker closes after all of them succeeded, 1 by default
+	HalfOpenRequests int
+}
+
+func (bc *BreakerConfig) setDefault() {
+	if bc.FailureThreshold <= 0 {
+		bc.FailureThreshold = 5
+	}
+	if bc.OpenTimeout <= 0 {
+		bc.OpenTimeout = 30 * time.Second
+	}
+	if bc.HalfOpenRequests <= 0 {
+		bc.HalfOpenRequests = 1
+	}
+}
+
+// `breaker` is the circuit breaker of a instance
+type breaker struct {
+	state     BreakerState
+	failures  int
+	trials    int
+	successes int
+	since     time.Time
+}
+
+// CircuitBreaker gives every instance of the target Selector
+// a closed/open/half-open circuit breaker.
+// Callers report the result of every request by `Report`,
+// a breaker opens after `FailureThreshold` consecutive failures,
+// becomes half-open after `OpenTimeout` and lets `HalfOpenRequests` trial requests through,
+// closes if all of them succeeded and opens again on any failure.
+//
+// `Select` skips the instances whose breaker rejects the request,
+// it asks the target again without the rejected ones and returns the zero value of I
+// if no instance was let through, so callers never loop without bound.
+// A half-open trial that is never reported is given up after another `OpenTimeout`.
+// `Done` and `Observe` are passed to the target, so a target such as `P2C` or `PeakEWMA`
+// keeps counting the outstanding requests and estimating the latency behind the breakers.
+type CircuitBreaker[T Hashable, I Instance[T]] struct {
+	mutex    sync.Mutex
+	target   Selector[T, I]
+	config   BreakerConfig
+	breakers map[T]*breaker
+}
+
+func NewCircuitBreaker[T Hashable, I Instance[T]](target Selector[T, I], config ...BreakerConfig) *CircuitBreaker[T, I] {
+	var c BreakerConfig
+	if len(config) != 0 {
+		c = config[0]
+	}
+	c.setDefault()
+	return &CircuitBreaker[T, I]{
+		target:   target,
+		config:   c,
+		breakers: make(map[T]*breaker),
+	}
+}
+
+// Add some instances to the target with a closed breaker
+// and return the number of successful operation
+func (cb *CircuitBreaker[T, I]) Add(instances ...I) int {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	count := 0
+	for _, instance := range instances {
+		if cb.target.Add(instance) == 1 {
+			cb.breakers[instance.InstanceID()] = &breaker{}
+			count++
+		}
+	}
+	return count
+}
+
+// Del some instances from the target and return the number of successful operation
+func (cb *CircuitBreaker[T, I]) Del(instances ...I) int {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	for _, instance := range instances {
+		delete(cb.breakers, instance.InstanceID())
+	}
+	return cb.target.Del(instances...)
+}
+
+// Replace the instances of the target with the new set in one step
+// and return the number of instances added or deleted,
+// the instances kept keep their breaker and the new ones get a closed breaker.
+func (cb *CircuitBreaker[T, I]) Replace(instances ...I) int {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	set := make(map[T]struct{}, len(instances))
+	for _, instance := range instances {
+		id := instance.InstanceID()
+		set[id] = struct{}{}
+		if _, ok := cb.breakers[id]; !ok {
+			cb.breakers[id] = &breaker{}
+		}
+	}
+	for id := range cb.breakers {
+		if _, ok := set[id]; !ok {
+			delete(cb.breakers, id)
+		}
+	}
+	return Replace[T, I](cb.target, instances...)
+}
+
+// Get the value corresponding to the key
+func (cb *CircuitBreaker[T, I]) Get(key T) (I, bool) {
+	return cb.target.Get(key)
+}
+
+// ForEach every instances whatever the state of its breaker is
+func (cb *CircuitBreaker[T, I]) ForEach(callback func(T, I) bool) {
+	cb.target.ForEach(callback)
+}
+
+func (cb *CircuitBreaker[T, I]) Size() int {
+	return cb.target.Size()
+}
+
+// State returns the state of the breaker of the instance
+func (cb *CircuitBreaker[T, I]) State(key T) BreakerState {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if b, ok := cb.breakers[key]; ok {
+		cb.refresh(b, time.Now())
+		return b.state
+	}
+	return BreakerClosed
+}
+
+// refresh moves a open breaker to half-open once the open timeout is over,
+// and gives up the unreported trials of a half-open breaker after another open timeout.
+func (cb *CircuitBreaker[T, I]) refresh(b *breaker, now time.Time) {
+	if b.state == BreakerClosed || now.Sub(b.since) < cb.config.OpenTimeout {
+		return
+	}
+	b.state = BreakerHalfOpen
+	b.trials, b.successes = 0, 0
+	b.since = now
+}
+
+// allow returns whether the breaker of the instance lets a request through
+func (cb *CircuitBreaker[T, I]) allow(id T, now time.Time) bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	b, ok := cb.breakers[id]
+	if !ok {
+		return true
+	}
+	cb.refresh(b, now)
+	switch b.state {
+	case BreakerClosed:
+		return true
+	case BreakerHalfOpen:
+		if b.trials < cb.config.HalfOpenRequests {
+			b.trials++
+			return true
+		}
+	}
+	return false
+}
+
+// Select a instance whose breaker lets the request through
+func (cb *CircuitBreaker[T, I]) Select() I {
+	ins, _ := cb.selectExcluding(nil)
+	return ins
+}
+
+// Pick selects a instance like `Select`, it returns `ErrAllUnhealthy`
+// if every instance is rejected by its breaker and `ErrNoInstances` if there is none
+func (cb *CircuitBreaker[T, I]) Pick(ctx context.Context) (I, error) {
+	return pick(ctx, func() (I, bool) { return cb.selectExcluding(nil) }, cb.Size)
+}
+
+// SelectN selects at most n distinct instances whose breaker lets the request through
+func (cb *CircuitBreaker[T, I]) SelectN(n int) []I {
+	return selectN[T, I](cb, n)
+}
+
+// SelectExcluding selects a instance whose breaker lets the request through
+// other than the excluded ones
+func (cb *CircuitBreaker[T, I]) SelectExcluding(exclude ...T) I {
+	ins, _ := cb.selectExcluding(exclude)
+	return ins
+}
+
+// selectExcluding asks the target again without the rejected instances,
+// so every instance is asked at most once.
+func (cb *CircuitBreaker[T, I]) selectExcluding(exclude []T) (ins I, ok bool) {
+	now := time.Now()
+	rejected := append([]T(nil), exclude...)
+	for i := cb.target.Size(); i >= 0; i-- {
+		ins, ok = selectExcluding(cb.target, rejected)
+		if !ok {
+			return
+		}
+		if cb.allow(ins.InstanceID(), now) {
+			return ins, true
+		}
+		// the target counted the rejected instance as outstanding
+		if la, ok := cb.target.(LoadAware[T, I]); ok {
+			la.Done(ins)
+		}
+		rejected = append(rejected, ins.InstanceID())
+	}
+	return *new(I), false
+}
+
+// Done passes the end of a request sent to the instance to the target if it is `LoadAware`
+func (cb *CircuitBreaker[T, I]) Done(ins I) {
+	if la, ok := cb.target.(LoadAware[T, I]); ok {
+		la.Done(ins)
+	}
+}
+
+// Observe passes the latency of a request sent to the instance to the target if it is a `Observer`
+func (cb *CircuitBreaker[T, I]) Observe(ins I, rtt time.Duration) {
+	if o, ok := cb.target.(Observer[T, I]); ok {
+		o.Observe(ins, rtt)
+	}
+}
+
+// Report the result of a request sent to the instance, a nil err means success
+func (cb *CircuitBreaker[T, I]) Report(ins I, err error) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	b, ok := cb.breakers[ins.InstanceID()]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	switch b.state {
+	case BreakerClosed:
+		if err == nil {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= cb.config.FailureThreshold {
+			b.state = BreakerOpen
+			b.since = now
+		}
+	case BreakerHalfOpen:
+		if err != nil {
+			b.state = BreakerOpen
+			b.since = now
+			return
+		}
+		b.successes++
+		if b.successes >= cb.config.HalfOpenRequests {
+			b.state = BreakerClosed
+			b.failures = 0
+		}
+	}
+}
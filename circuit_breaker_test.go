@@ -0,0 +1,178 @@
+package loadbalance_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ydmxcz/loadbalance"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	errFailed := errors.New("failed")
+	cb := loadbalance.NewCircuitBreaker[string, *myService](loadbalance.NewRoundRobin[string, *myService](),
+		loadbalance.BreakerConfig{
+			FailureThreshold: 2,
+			OpenTimeout:      50 * time.Millisecond,
+			HalfOpenRequests: 1,
+		})
+	ins := getInstance(1)
+	cb.Add(ins...)
+	bad := ins[0]
+
+	cb.Report(bad, errFailed)
+	cb.Report(bad, errFailed)
+	if s := cb.State(bad.Address); s != loadbalance.BreakerOpen {
+		t.Fatalf("breaker is %s, want open", s)
+	}
+	for i := 0; i < 100; i++ {
+		if cb.Select() == bad {
+			t.Fatal("selected a instance with open breaker")
+		}
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if s := cb.State(bad.Address); s != loadbalance.BreakerHalfOpen {
+		t.Fatalf("breaker is %s, want half-open", s)
+	}
+	// exactly one trial request is let through
+	trials := 0
+	for i := 0; i < 30; i++ {
+		if cb.Select() == bad {
+			trials++
+		}
+	}
+	if trials != 1 {
+		t.Fatalf("%d trial requests were let through, want 1", trials)
+	}
+	cb.Report(bad, nil)
+	if s := cb.State(bad.Address); s != loadbalance.BreakerClosed {
+		t.Fatalf("breaker is %s after a successful trial, want closed", s)
+	}
+}
+
+func TestCircuitBreakerAllOpen(t *testing.T) {
+	errFailed := errors.New("failed")
+	cb := loadbalance.NewCircuitBreaker[string, *myService](loadbalance.NewRandom[string, *myService](),
+		loadbalance.BreakerConfig{FailureThreshold: 1})
+	if cb.Select() != nil {
+		t.Fatal("empty balancer selected a instance")
+	}
+	ins := getInstance(1)
+	cb.Add(ins...)
+	for _, in := range ins {
+		cb.Report(in, errFailed)
+	}
+	if cb.Select() != nil {
+		t.Fatal("selected a instance while all breakers are open")
+	}
+	if cb.Size() != 3 {
+		t.Fatal("instances with open breaker were deleted")
+	}
+}
+
+func TestCircuitBreakerLoadAware(t *testing.T) {
+	pc := loadbalance.NewP2C[string, *myService]()
+	cb := loadbalance.NewCircuitBreaker[string, *myService](pc, loadbalance.BreakerConfig{FailureThreshold: 1})
+	ins := getInstance(1)
+	cb.Add(ins...)
+	bad := ins[0]
+	cb.Report(bad, errors.New("failed"))
+
+	// a instance is always selected while others are healthy
+	for i := 0; i < 100; i++ {
+		sel := cb.Select()
+		if sel == nil || sel == bad {
+			t.Fatalf("selected %v with the breaker of %s open", sel, bad.Address)
+		}
+		// the breaker passes it to the target
+		cb.Done(sel)
+	}
+	// the picks rejected by the breaker are not left outstanding in the target
+	for _, in := range ins {
+		if n := pc.Inflight(in.Address); n != 0 {
+			t.Fatalf("%s has %d outstanding requests", in.Address, n)
+		}
+	}
+
+	pe := loadbalance.NewPeakEWMA[string, *myService]()
+	cb = loadbalance.NewCircuitBreaker[string, *myService](pe)
+	cb.Add(ins...)
+	cb.Observe(ins[0], time.Second)
+	if e := pe.Estimate(ins[0].Address); e < 500*time.Millisecond {
+		t.Fatalf("the estimate of %s is %v after a latency of 1s passed by the breaker", ins[0].Address, e)
+	}
+}
+
+func TestCircuitBreakerSelectExcluding(t *testing.T) {
+	cb := loadbalance.NewCircuitBreaker[string, *myService](loadbalance.NewRoundRobin[string, *myService](),
+		loadbalance.BreakerConfig{FailureThreshold: 1})
+	ins := getInstance(1)
+	cb.Add(ins...)
+	cb.Report(ins[0], errors.New("failed"))
+	for i := 0; i < 100; i++ {
+		if sel := cb.SelectExcluding(ins[1].Address); sel != ins[2] {
+			t.Fatalf("selected %v, want %s", sel, ins[2].Address)
+		}
+	}
+	if sel := cb.SelectExcluding(ins[1].Address, ins[2].Address); sel != nil {
+		t.Fatalf("selected %s, the rest instance has a open breaker", sel.Address)
+	}
+}
+
+func TestCircuitBreakerSelectN(t *testing.T) {
+	cb := loadbalance.NewCircuitBreaker[string, *myService](loadbalance.NewRoundRobin[string, *myService](),
+		loadbalance.BreakerConfig{FailureThreshold: 1})
+	ins := getInstance(1)
+	cb.Add(ins...)
+	cb.Report(ins[0], errors.New("failed"))
+	for i := 0; i < 100; i++ {
+		res := cb.SelectN(3)
+		checkDistinct(t, res, 2)
+		if res[0] == ins[0] || res[1] == ins[0] {
+			t.Fatalf("selected %s with a open breaker", ins[0].Address)
+		}
+	}
+}
+
+func TestCircuitBreakerPick(t *testing.T) {
+	cb := loadbalance.NewCircuitBreaker[string, *myService](loadbalance.NewRoundRobin[string, *myService](),
+		loadbalance.BreakerConfig{FailureThreshold: 1})
+	if _, err := cb.Pick(context.Background()); !errors.Is(err, loadbalance.ErrNoInstances) {
+		t.Fatalf("got %v, want ErrNoInstances", err)
+	}
+	ins := getInstance(1)
+	cb.Add(ins...)
+	for _, in := range ins {
+		cb.Report(in, errors.New("failed"))
+	}
+	if _, err := cb.Pick(context.Background()); !errors.Is(err, loadbalance.ErrAllUnhealthy) {
+		t.Fatalf("got %v, want ErrAllUnhealthy", err)
+	}
+}
+
+func TestCircuitBreakerReplace(t *testing.T) {
+	errFailed := errors.New("failed")
+	cb := loadbalance.NewCircuitBreaker[string, *myService](loadbalance.NewRoundRobin[string, *myService](),
+		loadbalance.BreakerConfig{FailureThreshold: 1})
+	ins := getInstance(1)
+	cb.Add(ins[0], ins[1])
+	cb.Report(ins[0], errFailed)
+	if n := cb.Replace(ins...); n != 1 || cb.Size() != 3 {
+		t.Fatalf("replaced %d instances, %d left", n, cb.Size())
+	}
+	// the kept instance keeps its open breaker and the new one gets a closed breaker
+	if cb.State(ins[0].Address) != loadbalance.BreakerOpen {
+		t.Fatal("the breaker of a kept instance was reset")
+	}
+	if cb.State(ins[2].Address) != loadbalance.BreakerClosed {
+		t.Fatal("the breaker of a new instance is not closed")
+	}
+	for i := 0; i < 100; i++ {
+		if sel := cb.Select(); sel == nil || sel == ins[0] {
+			t.Fatalf("selected %v after replace", sel)
+		}
+	}
+	checkReplaceAtomic(t, loadbalance.NewCircuitBreaker[string, *myService](loadbalance.NewRoundRobin[string, *myService]()))
+}
@@ -1,7 +1,9 @@
 package loadbalance
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"sort"
 	"sync"
 
@@ -25,6 +27,9 @@ func (s uint64Slice) Swap(i, j int) {
 	s[i], s[j] = s[j], s[i]
 }
 
+// defaultLoadFactor is the factor `c` of bounded loads used if `SetLoadFactor` was not called
+const defaultLoadFactor = 1.25
+
 type ConsistentHash[T Hashable] struct {
 	mux         sync.RWMutex
 	hashfunc    strHashFunc
@@ -32,11 +37,25 @@ type ConsistentHash[T Hashable] struct {
 	keys        uint64Slice                      //已排序的节点hash切片
 	keyMap      *haxmap.Map[uint64, Instance[T]] //节点哈希和key的map, 键是hash值，值是节点key
 	instanceMap *haxmap.Map[T, Instance[T]]      //节点哈希和key的map, 键是hash值，值是节点key
+	vnodeNum    map[T]int                        //每个节点添加时的虚拟节点数量
+	loadFactor  float64                          //有界负载的因子c
+	loads       map[T]int64                      //每个节点当前被分配的key数量
+	acquired    map[string][]acquisition[T]      //每个key被分配到的节点
+	totalLoad   int64                            //所有节点的负载之和
+	generation  uint64                           //添加节点的次数，区分删除后重新添加的同一节点
+	generations map[T]uint64                     //每个节点添加时的generation
+}
+
+// acquisition is a key assigned to the instance by `Acquire`,
+// the generation tells whether the instance was deleted and added again since.
+type acquisition[T Hashable] struct {
+	id         T
+	generation uint64
 }
 
 func NewConsistentHash[T Hashable](replicas ...int) *ConsistentHash[T] {
 	r := 3
-	if len(replicas) != 0 {
+	if len(replicas) != 0 && replicas[0] > 0 {
 		r = replicas[0]
 	}
 	m := &ConsistentHash[T]{
@@ -44,6 +63,11 @@ func NewConsistentHash[T Hashable](replicas ...int) *ConsistentHash[T] {
 		hashfunc:    GetHashFunc[string](),
 		keyMap:      haxmap.New[uint64, Instance[T]](),
 		instanceMap: haxmap.New[T, Instance[T]](),
+		vnodeNum:    make(map[T]int),
+		loadFactor:  defaultLoadFactor,
+		loads:       make(map[T]int64),
+		acquired:    make(map[string][]acquisition[T]),
+		generations: make(map[T]uint64),
 	}
 	return m
 }
@@ -56,87 +80,314 @@ func (c *ConsistentHash[T]) ForEach(callback func(T, Instance[T]) bool) {
 	haxMapForEach(c.instanceMap, callback)
 }
 
+// vnodes returns the number of virtual node of the instance,
+// that is the replicas scaled by its weight.
+func (c *ConsistentHash[T]) vnodes(instance Instance[T]) int {
+	return c.replicas * instanceWeight[T](instance)
+}
+
+func (c *ConsistentHash[T]) vnodeHash(id T, i int) uint64 {
+	return c.hashfunc(fmt.Sprintf("%v-%d", id, i))
+}
+
 // Add 方法用来添加缓存节点，参数为节点key，比如使用IP
+// 每个节点的虚拟节点数量为 replicas * InstanceWeight()
 func (c *ConsistentHash[T]) Add(instances ...Instance[T]) int {
 	c.mux.Lock()
 	defer c.mux.Unlock()
+	return c.add(instances)
+}
+
+func (c *ConsistentHash[T]) add(instances []Instance[T]) int {
 	count := 0
-	flag := true
 	for _, instance := range instances {
-		flag = true
 		id := instance.InstanceID()
-		for i := 0; i < c.replicas; i++ {
-
-			if _, ok := c.instanceMap.GetOrSet(id, instance); !ok {
-				hash := c.hashfunc(fmt.Sprintf("%v-%d", id, i))
-				c.keys = append(c.keys, hash)
-				c.keyMap.Set(hash, instance)
-			} else {
-				flag = false
-				break
-			}
+		if _, ok := c.instanceMap.GetOrSet(id, instance); ok {
+			continue
 		}
-		if flag {
-			count++
+		v := c.vnodes(instance)
+		for i := 0; i < v; i++ {
+			hash := c.vnodeHash(id, i)
+			c.keys = append(c.keys, hash)
+			c.keyMap.Set(hash, instance)
 		}
+		c.vnodeNum[id] = v
+		c.generation++
+		c.generations[id] = c.generation
+		count++
+	}
+	if count > 0 {
+		sort.Sort(c.keys)
 	}
-	sort.Sort(c.keys)
 	return count
 }
 
-func (c *ConsistentHash[T]) delSlice(val uint64) {
-	for i := 0; i < len(c.keys); i++ {
-		if c.keys[i] == val {
-			c.keys = append(c.keys[:i], c.keys[i+1:]...)
-			break
+// delKeys 从已排序的节点hash切片中删除一组hash
+func (c *ConsistentHash[T]) delKeys(hashes map[uint64]struct{}) {
+	keys := c.keys[:0]
+	for _, k := range c.keys {
+		if _, ok := hashes[k]; !ok {
+			keys = append(keys, k)
 		}
 	}
+	c.keys = keys
 }
 
 func (c *ConsistentHash[T]) Size() int {
-	return int(c.keyMap.Len())
+	return int(c.instanceMap.Len())
 }
 
 func (c *ConsistentHash[T]) Del(instances ...Instance[T]) int {
 	c.mux.Lock()
 	defer c.mux.Unlock()
+	return c.del(instances)
+}
+
+func (c *ConsistentHash[T]) del(instances []Instance[T]) int {
 	count := 0
-	flag := true
+	hashes := make(map[uint64]struct{})
 	for _, instance := range instances {
-		flag = true
 		id := instance.InstanceID()
-		for i := 0; i < c.replicas; i++ {
-			if _, ok := c.instanceMap.Get(id); ok {
-				c.instanceMap.Del(id)
-				hash := c.hashfunc(fmt.Sprintf("%v-%d", id, i))
-				c.keyMap.Del(hash)
-				c.delSlice(hash)
-			} else {
-				flag = false
-				break
+		if _, ok := c.instanceMap.Get(id); !ok {
+			continue
+		}
+		c.instanceMap.Del(id)
+		c.totalLoad -= c.loads[id]
+		delete(c.loads, id)
+		delete(c.generations, id)
+		for i := 0; i < c.vnodeNum[id]; i++ {
+			hash := c.vnodeHash(id, i)
+			c.keyMap.Del(hash)
+			hashes[hash] = struct{}{}
+		}
+		delete(c.vnodeNum, id)
+		count++
+	}
+	if count > 0 {
+		c.delKeys(hashes)
+	}
+	return count
+}
+
+// Update 方法用来更新已存在的节点，参数为新的节点
+// 节点的权重变化后按新的权重增加或删除虚拟节点，
+// 只有新增或删除的虚拟节点上的key会移动
+func (c *ConsistentHash[T]) Update(instances ...Instance[T]) int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.update(instances)
+}
+
+func (c *ConsistentHash[T]) update(instances []Instance[T]) int {
+	count := 0
+	added := false
+	hashes := make(map[uint64]struct{})
+	for _, instance := range instances {
+		id := instance.InstanceID()
+		if _, ok := c.instanceMap.Get(id); !ok {
+			continue
+		}
+		c.instanceMap.Set(id, instance)
+		old, v := c.vnodeNum[id], c.vnodes(instance)
+		for i := 0; i < v; i++ {
+			hash := c.vnodeHash(id, i)
+			c.keyMap.Set(hash, instance)
+			if i >= old {
+				c.keys = append(c.keys, hash)
+				added = true
 			}
 		}
-		if flag {
-			count++
+		for i := v; i < old; i++ {
+			hash := c.vnodeHash(id, i)
+			c.keyMap.Del(hash)
+			hashes[hash] = struct{}{}
 		}
+		c.vnodeNum[id] = v
+		count++
+	}
+	if len(hashes) > 0 {
+		c.delKeys(hashes)
+	}
+	if added {
+		sort.Sort(c.keys)
 	}
 	return count
 }
 
+// Replace 方法用新的节点集合一次性替换全部节点，返回新增和删除的节点数量
+// 保留的节点按新的权重更新虚拟节点，读取方只会看到替换前或替换后的节点集合
+func (c *ConsistentHash[T]) Replace(instances ...Instance[T]) int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	added, kept, set := splitReplace(instances, func(id T) bool {
+		_, ok := c.instanceMap.Get(id)
+		return ok
+	})
+	var dels []Instance[T]
+	c.instanceMap.ForEach(func(id T, instance Instance[T]) bool {
+		if _, ok := set[id]; !ok {
+			dels = append(dels, instance)
+		}
+		return true
+	})
+	count := c.del(dels)
+	c.update(kept)
+	return count + c.add(added)
+}
+
 // Select 方法根据给定的对象获取最靠近它的那个节点
 func (c *ConsistentHash[T]) Select(key string) Instance[T] {
-	if c.keyMap.Len() == 0 {
-		return nil
-	}
 	hash := c.hashfunc(key)
 	c.mux.RLock()
 	defer c.mux.RUnlock()
+	if len(c.keys) == 0 {
+		return nil
+	}
 	idx := sort.Search(len(c.keys), func(i int) bool { return c.keys[i] >= hash })
-	k := c.keys[idx]
-
+	// 超过最后一个节点时回到环的起点
 	if idx == len(c.keys) {
 		idx = 0
 	}
-	ins, _ := c.keyMap.Get(k)
+	ins, _ := c.keyMap.Get(c.keys[idx])
+	return ins
+}
+
+// SelectBy selects the closest instance clockwise from the key like `Select`, so ConsistentHash is a SelectorBy
+func (c *ConsistentHash[T]) SelectBy(key string) Instance[T] {
+	ins, _ := c.selectByExcluding(key, nil)
+	return ins
+}
+
+// PickBy selects a instance for the key like `SelectBy`, or returns `ErrNoInstances` if there is none
+func (c *ConsistentHash[T]) PickBy(ctx context.Context, key string) (Instance[T], error) {
+	return pick(ctx, func() (Instance[T], bool) { return c.selectByExcluding(key, nil) }, nil)
+}
+
+// SelectByExcluding walks clockwise from the key to the closest instance other than the excluded ones
+func (c *ConsistentHash[T]) SelectByExcluding(key string, exclude ...T) Instance[T] {
+	ins, _ := c.selectByExcluding(key, exclude)
 	return ins
 }
+
+func (c *ConsistentHash[T]) selectByExcluding(key string, exclude []T) (Instance[T], bool) {
+	hash := c.hashfunc(key)
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	idx := sort.Search(len(c.keys), func(i int) bool { return c.keys[i] >= hash })
+	for i := 0; i < len(c.keys); i++ {
+		ins, ok := c.keyMap.Get(c.keys[(idx+i)%len(c.keys)])
+		if ok && !excluded(exclude, ins.InstanceID()) {
+			return ins, true
+		}
+	}
+	return nil, false
+}
+
+// SelectNBy walks clockwise from the key and returns at most n distinct instances,
+// the first one is the instance `Select` returns.
+// A instance with a larger weight has more virtual nodes, so it is met earlier on the ring.
+func (c *ConsistentHash[T]) SelectNBy(key string, n int) []Instance[T] {
+	if n <= 0 {
+		return nil
+	}
+	hash := c.hashfunc(key)
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	if size := len(c.vnodeNum); n > size {
+		n = size
+	}
+	res := make([]Instance[T], 0, n)
+	idx := sort.Search(len(c.keys), func(i int) bool { return c.keys[i] >= hash })
+	for i := 0; i < len(c.keys) && len(res) < n; i++ {
+		ins, ok := c.keyMap.Get(c.keys[(idx+i)%len(c.keys)])
+		if !ok {
+			continue
+		}
+		dup := false
+		for _, r := range res {
+			if r.InstanceID() == ins.InstanceID() {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			res = append(res, ins)
+		}
+	}
+	return res
+}
+
+// SetLoadFactor sets the factor `c` of consistent hashing with bounded loads (Mirrokni et al.),
+// `Acquire` never assigns more than `ceil(c * average)` keys to a instance.
+// The factor must be at least 1, the default is 1.25.
+// A smaller factor spreads the load more evenly but moves more keys away from their own instance.
+func (c *ConsistentHash[T]) SetLoadFactor(factor float64) {
+	if factor < 1 {
+		factor = 1
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.loadFactor = factor
+}
+
+// Load returns the number of keys currently acquired on the instance
+func (c *ConsistentHash[T]) Load(id T) int64 {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.loads[id]
+}
+
+// Acquire assigns the key to the closest instance like `Select`,
+// but skips the instances which already hold their share of the keys currently assigned
+// and walks clockwise to the next instance under capacity.
+// Every successful `Acquire` must be paired with a `Release` of the same key.
+func (c *ConsistentHash[T]) Acquire(key string) Instance[T] {
+	hash := c.hashfunc(key)
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	n := int64(c.instanceMap.Len())
+	if n == 0 || len(c.keys) == 0 {
+		return nil
+	}
+	capacity := int64(math.Ceil(c.loadFactor * float64(c.totalLoad+1) / float64(n)))
+	idx := sort.Search(len(c.keys), func(i int) bool { return c.keys[i] >= hash })
+	for i := 0; i < len(c.keys); i++ {
+		ins, ok := c.keyMap.Get(c.keys[(idx+i)%len(c.keys)])
+		if !ok {
+			continue
+		}
+		id := ins.InstanceID()
+		if c.loads[id] < capacity {
+			c.loads[id]++
+			c.totalLoad++
+			c.acquired[key] = append(c.acquired[key], acquisition[T]{id, c.generations[id]})
+			return ins
+		}
+	}
+	return nil
+}
+
+// Release gives back a key assigned by `Acquire`,
+// a key acquired more than once is released from the instances in the order it was acquired.
+func (c *ConsistentHash[T]) Release(key string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	as, ok := c.acquired[key]
+	if !ok {
+		return
+	}
+	a := as[0]
+	if len(as) == 1 {
+		delete(c.acquired, key)
+	} else {
+		c.acquired[key] = as[1:]
+	}
+	// the instance may have been deleted since, the load of the one added again is not of this key
+	if gen, ok := c.generations[a.id]; !ok || gen != a.generation {
+		return
+	}
+	if c.loads[a.id] > 0 {
+		c.loads[a.id]--
+		c.totalLoad--
+	}
+}
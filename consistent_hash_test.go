@@ -1,9 +1,13 @@
 package loadbalance
 
 import (
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"math"
 	"math/rand"
+	"sort"
 	"testing"
 	"time"
 	"unsafe"
@@ -78,3 +82,227 @@ func TestOne(t *testing.T) {
 	fmt.Println("方法二生成12位随机字符串: ", RandStr2(12))
 	fmt.Println("方法三生成12位随机字符串: ", RandStr3(12))
 }
+
+type chInstance string
+
+func (ci chInstance) InstanceID() string {
+	return string(ci)
+}
+
+func (ci chInstance) InstanceWeight() int {
+	return 1
+}
+
+func TestConsistentHashBoundedLoad(t *testing.T) {
+	c := NewConsistentHash[string]()
+	c.Add(chInstance("a"), chInstance("b"), chInstance("c"))
+	c.SetLoadFactor(1.25)
+
+	// a hot key is spread once its own instance is full
+	for i := 1; i <= 100; i++ {
+		if c.Acquire("hot") == nil {
+			t.Fatal("acquire failed")
+		}
+		capacity := int64(math.Ceil(1.25 * float64(i) / 3))
+		for _, id := range []string{"a", "b", "c"} {
+			if l := c.Load(id); l > capacity {
+				t.Fatalf("load of %s is %d after %d keys, capacity is %d", id, l, i, capacity)
+			}
+		}
+	}
+	for i := 0; i < 100; i++ {
+		c.Release("hot")
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if l := c.Load(id); l != 0 {
+			t.Fatalf("load of %s is %d after release", id, l)
+		}
+	}
+	// releasing a key which was never acquired is harmless
+	c.Release("cold")
+
+	// a key acquired before its instance was deleted and added again
+	// is not taken from the load of the new one
+	ins := c.Acquire("stale")
+	c.Del(ins)
+	c.Add(ins)
+	if c.Acquire("stale") != ins {
+		t.Fatalf("the key is not on %s again", ins.InstanceID())
+	}
+	c.Release("stale")
+	if l := c.Load(ins.InstanceID()); l != 1 {
+		t.Fatalf("load of %s is %d after releasing the key acquired before", ins.InstanceID(), l)
+	}
+	c.Release("stale")
+	if l := c.Load(ins.InstanceID()); l != 0 {
+		t.Fatalf("load of %s is %d after releasing the key acquired again", ins.InstanceID(), l)
+	}
+}
+
+type chWeighted struct {
+	id     string
+	weight int
+}
+
+func (cw *chWeighted) InstanceID() string {
+	return cw.id
+}
+
+func (cw *chWeighted) InstanceWeight() int {
+	return cw.weight
+}
+
+func TestConsistentHashWeightedVnodes(t *testing.T) {
+	c := NewConsistentHash[string](20)
+	small, big := &chWeighted{"8G", 8}, &chWeighted{"64G", 64}
+	if c.Add(small, big) != 2 || c.Size() != 2 {
+		t.Fatal("add failed")
+	}
+	if len(c.keys) != 20*(8+64) {
+		t.Fatalf("ring has %d virtual nodes, want %d", len(c.keys), 20*(8+64))
+	}
+
+	before := map[string]Instance[string]{}
+	m := map[string]int{}
+	for i := 0; i < 9000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		ins := c.Select(key)
+		before[key] = ins
+		m[ins.InstanceID()]++
+	}
+	// about 1000 : 8000
+	if m["8G"] < 700 || m["8G"] > 1300 {
+		t.Fatalf("8G instance got %d of 9000 keys", m["8G"])
+	}
+
+	// the small instance grows, keys only move to it
+	c.Update(&chWeighted{"8G", 32})
+	if len(c.keys) != 20*(32+64) {
+		t.Fatalf("ring has %d virtual nodes after update, want %d", len(c.keys), 20*(32+64))
+	}
+	for key, old := range before {
+		if ins := c.Select(key); ins.InstanceID() != old.InstanceID() && ins.InstanceID() != "8G" {
+			t.Fatalf("key %s moved to %s", key, ins.InstanceID())
+		}
+	}
+
+	// and shrinks back to exactly the old ring
+	c.Update(small)
+	for key, old := range before {
+		if ins := c.Select(key); ins.InstanceID() != old.InstanceID() {
+			t.Fatalf("key %s moved from %s to %s", key, old.InstanceID(), ins.InstanceID())
+		}
+	}
+
+	if c.Del(big) != 1 || c.Size() != 1 || len(c.keys) != 20*8 {
+		t.Fatal("delete failed")
+	}
+}
+
+func TestConsistentHashSelectWraparound(t *testing.T) {
+	c := NewConsistentHash[string]()
+	if c.Select("key") != nil {
+		t.Fatal("select from a empty ring")
+	}
+	c.Add(&chWeighted{"a", 1}, &chWeighted{"b", 2})
+	if c.Size() != 2 {
+		t.Fatalf("size is %d with 2 instances", c.Size())
+	}
+	// a key past the last virtual node belongs to the first one
+	first, _ := c.keyMap.Get(c.keys[0])
+	n := 0
+	for i := 0; n < 10; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if c.hashfunc(key) <= c.keys[len(c.keys)-1] {
+			continue
+		}
+		n++
+		if ins := c.Select(key); ins != first {
+			t.Fatalf("key %s past the ring got %v, want %v", key, ins, first)
+		}
+	}
+}
+
+// A retry excluding the instance of the key walks clockwise to the next distinct instance on the ring
+func TestConsistentHashSelectByExcluding(t *testing.T) {
+	c := NewConsistentHash[string]()
+	c.Add(&chWeighted{"a", 1}, &chWeighted{"b", 2}, &chWeighted{"c", 3})
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		first := c.SelectBy(key)
+		idx := sort.Search(len(c.keys), func(i int) bool { return c.keys[i] >= c.hashfunc(key) })
+		var want Instance[string]
+		for j := 0; want == nil; j++ {
+			if ins, _ := c.keyMap.Get(c.keys[(idx+j)%len(c.keys)]); ins != first {
+				want = ins
+			}
+		}
+		if sel := c.SelectByExcluding(key, first.InstanceID()); sel != want {
+			t.Fatalf("key %s went to %v without %s, want %v", key, sel, first.InstanceID(), want)
+		}
+	}
+	if sel := c.SelectByExcluding("key", "a", "b", "c"); sel != nil {
+		t.Fatalf("got %s when all are excluded", sel.InstanceID())
+	}
+}
+
+// The replicas of a key are the next distinct instances clockwise, where the retries go
+func TestConsistentHashSelectNBy(t *testing.T) {
+	c := NewConsistentHash[string]()
+	if res := c.SelectNBy("key", 2); len(res) != 0 {
+		t.Fatalf("got %v from a empty ring", res)
+	}
+	c.Add(&chWeighted{"a", 1}, &chWeighted{"b", 2}, &chWeighted{"c", 3})
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		res := c.SelectNBy(key, 5)
+		if len(res) != 3 || res[0] != c.SelectBy(key) ||
+			res[1] != c.SelectByExcluding(key, res[0].InstanceID()) ||
+			res[2] != c.SelectByExcluding(key, res[0].InstanceID(), res[1].InstanceID()) {
+			t.Fatalf("replicas of key %s are %v", key, res)
+		}
+	}
+}
+
+func TestConsistentHashPickBy(t *testing.T) {
+	c := NewConsistentHash[string]()
+	if _, err := c.PickBy(context.Background(), "key"); !errors.Is(err, ErrNoInstances) {
+		t.Fatalf("got %v, want ErrNoInstances", err)
+	}
+	a, b := &chWeighted{"a", 1}, &chWeighted{"b", 2}
+	c.Add(a, b)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if sel, err := c.PickBy(context.Background(), key); err != nil || sel != c.SelectBy(key) {
+			t.Fatalf("picked %v, %v for key %s", sel, err, key)
+		}
+	}
+	if n := c.Del(a, b); n != 2 || c.Size() != 0 {
+		t.Fatalf("deleted %d instances, %d left", n, c.Size())
+	}
+	if _, err := c.PickBy(context.Background(), "key"); !errors.Is(err, ErrNoInstances) {
+		t.Fatalf("got %v after delete, want ErrNoInstances", err)
+	}
+}
+
+func TestConsistentHashReplace(t *testing.T) {
+	ins := []Instance[string]{
+		chInstance("192.168.0.5:1"),
+		chInstance("192.168.0.3:1"),
+		chInstance("192.168.0.2:1"),
+	}
+	c := NewConsistentHash[string]()
+	c.Add(ins[0], ins[1])
+	if n := c.Replace(ins[1], ins[2]); n != 2 || c.Size() != 2 {
+		t.Fatalf("replaced %d instances, %d left", n, c.Size())
+	}
+	// the ring is the one of the new set
+	want := NewConsistentHash[string]()
+	want.Add(ins[1], ins[2])
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if sel := c.SelectBy(key); sel != want.SelectBy(key) {
+			t.Fatalf("key %s went to %v after replace", key, sel)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package loadbalance
 
 import (
+	"context"
 	"sync"
 
 	"github.com/alphadose/haxmap"
@@ -59,9 +60,18 @@ func (q *queue[T]) Empty() bool {
 // `instanceWrapper` which is includes two fileds
 // that one named `instance`(a type implements generic interface `Instance[T]`)
 // and the other named `weight` (int).
+// `total` is the weight of the current round and `node` is the node of queue holding it.
 type instanceWrapper[T Hashable, I Instance[T]] struct {
 	instance I
 	weight   int
+	total    int
+	node     *queueNode[*instanceWrapper[T, I]]
+}
+
+// reset the weight of a new round by the method of instance named `InstanceWeight()`
+func (iw *instanceWrapper[T, I]) reset() {
+	iw.total = instanceWeight[T](iw.instance)
+	iw.weight = iw.total
 }
 
 // DynamicWeighted uses two queue implements
@@ -127,7 +137,6 @@ func (sl *DynamicWeighted[T, I]) Add(instances ...I) int {
 	for _, instance := range instances {
 		// get the weight and unique-id of instance
 		instanceId := instance.InstanceID()
-		instanceWeight := instance.InstanceWeight()
 		// query from hashmap,do add operator if not exit
 		_, ok := sl.hashmap.Get(instanceId)
 		if ok {
@@ -135,9 +144,10 @@ func (sl *DynamicWeighted[T, I]) Add(instances ...I) int {
 		} else {
 			iw := &instanceWrapper[T, I]{
 				instance: instance,
-				weight:   instanceWeight,
 			}
+			iw.reset()
 			n := newNode(iw)
+			iw.node = n
 
 			sl.mutex.Lock()
 			sl.mqueue.push(n)
@@ -188,6 +198,79 @@ func (sl *DynamicWeighted[T, I]) Del(instances ...I) int {
 	return count
 }
 
+// Update the instances already added with their new weights
+// and return the number of successful operation.
+// The new weight applies at once instead of at the next round:
+// the instance keeps the selections it already had in the current round
+// and gets the rest of its new weight, or waits for the next round if it already had them all.
+func (sl *DynamicWeighted[T, I]) Update(instances ...I) int {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+	count := 0
+	for _, instance := range instances {
+		if sl.update(instance) {
+			count++
+		}
+	}
+	return count
+}
+
+// update a instance already added, the caller must hold the mutex.
+func (sl *DynamicWeighted[T, I]) update(instance I) bool {
+	instanceId := instance.InstanceID()
+	old, ok := sl.hashmap.Get(instanceId)
+	if !ok {
+		return false
+	}
+	// a new wrapper takes the place of the old one in its queue,
+	// so `Get` never reads a instance being written
+	iw := &instanceWrapper[T, I]{
+		instance: instance,
+		node:     old.node,
+	}
+	iw.reset()
+	iw.weight -= old.total - old.weight
+	iw.node.val = iw
+	sl.hashmap.Set(instanceId, iw)
+	return true
+}
+
+// Replace the instances with the new set in one step
+// and return the number of instances added or deleted,
+// the instances kept are updated with their new weight like `Update`.
+func (sl *DynamicWeighted[T, I]) Replace(instances ...I) int {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+	added, kept, set := splitReplace(instances, func(id T) bool {
+		_, ok := sl.hashmap.Get(id)
+		return ok
+	})
+	var dels []*instanceWrapper[T, I]
+	sl.hashmap.ForEach(func(id T, iw *instanceWrapper[T, I]) bool {
+		if _, ok := set[id]; !ok {
+			dels = append(dels, iw)
+		}
+		return true
+	})
+	for _, iw := range dels {
+		sl.hashmap.Del(iw.instance.InstanceID())
+		iw.weight = minInt64
+	}
+	for _, instance := range kept {
+		sl.update(instance)
+	}
+	for _, instance := range added {
+		iw := &instanceWrapper[T, I]{
+			instance: instance,
+		}
+		iw.reset()
+		iw.node = newNode(iw)
+		sl.mqueue.push(iw.node)
+		sl.hashmap.Set(instance.InstanceID(), iw)
+	}
+	return len(added) + len(dels)
+}
+
 func (sl *DynamicWeighted[T, I]) Size() int {
 	return int(sl.hashmap.Len())
 }
@@ -233,10 +316,16 @@ repop:
 		// 重新出队一个节点
 		goto repop
 	}
+	// 权重更新前该实例已用完本轮的次数，等待下一轮
+	if inst.weight <= 0 {
+		inst.reset()
+		sl.squeue.push(instNode)
+		goto repop
+	}
 	inst.weight--
 	if inst.weight == 0 {
 		// 重新获取权重
-		inst.weight = inst.instance.InstanceWeight()
+		inst.reset()
 		sl.squeue.push(instNode)
 	} else {
 		sl.mqueue.push(instNode)
@@ -244,3 +333,118 @@ repop:
 	// 返回实例
 	return inst.instance
 }
+
+// takeExcluding unlinks and returns the first node of the queue
+// that is neither deleted nor excluded, deleted nodes met on the way are dropped.
+func takeExcluding[T Hashable, I Instance[T]](q *queue[*instanceWrapper[T, I]],
+	exclude []T) *queueNode[*instanceWrapper[T, I]] {
+	var prev *queueNode[*instanceWrapper[T, I]]
+	for n := q.head; n != nil; {
+		next := n.next
+		deleted := n.val.weight == minInt64
+		if deleted || !excluded(exclude, n.val.instance.InstanceID()) {
+			if prev == nil {
+				q.head = next
+			} else {
+				prev.next = next
+			}
+			if q.tail == n {
+				q.tail = prev
+			}
+			if !deleted {
+				return n
+			}
+		} else {
+			prev = n
+		}
+		n = next
+	}
+	return nil
+}
+
+// Pick selects a instance like `Select`, or returns `ErrNoInstances` if there is none
+func (sl *DynamicWeighted[T, I]) Pick(ctx context.Context) (I, error) {
+	return pick(ctx, func() (I, bool) { return sl.selectExcluding(nil) }, nil)
+}
+
+// SelectN selects at most n distinct instances by weight
+func (sl *DynamicWeighted[T, I]) SelectN(n int) []I {
+	return selectN[T, I](sl, n)
+}
+
+// SelectExcluding selects a instance other than the excluded ones.
+// It takes the first instance of the main queue that is not excluded,
+// if the main queue has only excluded instances left the round is ended at once like `ShardedDynamicWeighted`,
+// so the others are still selected by their weights.
+func (sl *DynamicWeighted[T, I]) SelectExcluding(exclude ...T) I {
+	ins, _ := sl.selectExcluding(exclude)
+	return ins
+}
+
+func (sl *DynamicWeighted[T, I]) selectExcluding(exclude []T) (ins I, ok bool) {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+	return sl.next(exclude)
+}
+
+// next selects a instance other than the excluded ones, the caller must hold the mutex.
+func (sl *DynamicWeighted[T, I]) next(exclude []T) (ins I, ok bool) {
+	for restarted := false; ; {
+		if ins, ok = sl.takeMain(exclude); ok {
+			return
+		}
+		switch {
+		case sl.mqueue.Empty() && sl.squeue.Empty():
+			return
+		case sl.mqueue.Empty():
+			// the round is over
+			sl.mqueue, sl.squeue = sl.squeue, sl.mqueue
+		case restarted:
+			// a whole new round has nothing but the excluded instances
+			return
+		default:
+			// the main queue only has excluded instances left, end the round
+			sl.restart()
+			restarted = true
+		}
+	}
+}
+
+// takeMain selects a instance other than the excluded ones from the main queue only,
+// ok is false if the main queue has none of them left in the round. the caller must hold the mutex.
+func (sl *DynamicWeighted[T, I]) takeMain(exclude []T) (ins I, ok bool) {
+	for instNode := takeExcluding(sl.mqueue, exclude); instNode != nil; instNode = takeExcluding(sl.mqueue, exclude) {
+		inst := instNode.val
+		// the instance already had its share of the round before its weight was updated
+		if inst.weight <= 0 {
+			inst.reset()
+			sl.squeue.push(instNode)
+			continue
+		}
+		inst.weight--
+		if inst.weight == 0 {
+			inst.reset()
+			sl.squeue.push(instNode)
+		} else {
+			sl.mqueue.push(instNode)
+		}
+		return inst.instance, true
+	}
+	return
+}
+
+// restart a new round at once: every instance gets back its whole weight in the main queue
+// and the deleted ones are given to gc. the caller must hold the mutex.
+func (sl *DynamicWeighted[T, I]) restart() {
+	q := &queue[*instanceWrapper[T, I]]{}
+	for _, src := range []*queue[*instanceWrapper[T, I]]{sl.mqueue, sl.squeue} {
+		for n := src.pop(); n != nil; n = src.pop() {
+			if n.val.weight == minInt64 {
+				continue
+			}
+			n.val.reset()
+			q.push(n)
+		}
+	}
+	sl.mqueue, sl.squeue = q, &queue[*instanceWrapper[T, I]]{}
+}
@@ -1,7 +1,10 @@
 package loadbalance_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"testing"
 
 	"github.com/ydmxcz/loadbalance"
 )
@@ -47,3 +50,154 @@ func Example() {
 	})
 
 }
+
+func TestDynamicWeightedSelectExcluding(t *testing.T) {
+	dw := loadbalance.NewDynamicWeighted[string, *myService]()
+	ins := getInstance(1)
+	dw.Add(ins...)
+
+	// without exclusion it goes through the rounds like `Select`
+	m := map[string]int{}
+	for i := 0; i < 100; i++ {
+		m[dw.SelectExcluding().Address]++
+	}
+	for _, in := range ins {
+		if m[in.Address] != in.Memory*10 {
+			t.Fatalf("%s was selected %d times, want %d: %v", in.Address, m[in.Address], in.Memory*10, m)
+		}
+	}
+
+	// the others are still selected by their weights when the heaviest one is excluded
+	m = map[string]int{}
+	for i := 0; i < 10000; i++ {
+		m[dw.SelectExcluding(ins[0].Address).Address]++
+	}
+	if m[ins[0].Address] != 0 || m[ins[1].Address] != 6000 || m[ins[2].Address] != 4000 {
+		t.Fatalf("got %v when %s is excluded, want 6000 and 4000", m, ins[0].Address)
+	}
+
+	if sel := dw.SelectExcluding(ins[0].Address, ins[1].Address, ins[2].Address); sel != nil {
+		t.Fatalf("got %s when all are excluded", sel.Address)
+	}
+}
+
+func TestDynamicWeightedSelectN(t *testing.T) {
+	dw := loadbalance.NewDynamicWeighted[string, *myService]()
+	checkDistinct(t, dw.SelectN(2), 0)
+	ins := getInstance(1)
+	dw.Add(ins...)
+
+	// SelectN(1) goes through the rounds like `Select`
+	m := map[string]int{}
+	for i := 0; i < 100; i++ {
+		res := dw.SelectN(1)
+		checkDistinct(t, res, 1)
+		m[res[0].Address]++
+	}
+	for _, in := range ins {
+		if m[in.Address] != in.Memory*10 {
+			t.Fatalf("%s was selected %d times, want %d: %v", in.Address, m[in.Address], in.Memory*10, m)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		checkDistinct(t, dw.SelectN(2), 2)
+		checkDistinct(t, dw.SelectN(5), len(ins))
+	}
+}
+
+func TestDynamicWeightedPick(t *testing.T) {
+	dw := loadbalance.NewDynamicWeighted[string, *myService]()
+	if _, err := dw.Pick(context.Background()); !errors.Is(err, loadbalance.ErrNoInstances) {
+		t.Fatalf("got %v, want ErrNoInstances", err)
+	}
+	ins := getInstance(1)
+	dw.Add(ins...)
+
+	// Pick goes through the rounds like `Select`
+	m := map[string]int{}
+	for i := 0; i < 100; i++ {
+		sel, err := dw.Pick(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		m[sel.Address]++
+	}
+	for _, in := range ins {
+		if m[in.Address] != in.Memory*10 {
+			t.Fatalf("%s was picked %d times, want %d: %v", in.Address, m[in.Address], in.Memory*10, m)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := dw.Pick(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	dw.Del(ins...)
+	if _, err := dw.Pick(context.Background()); !errors.Is(err, loadbalance.ErrNoInstances) {
+		t.Fatalf("got %v after delete, want ErrNoInstances", err)
+	}
+}
+
+func TestDynamicWeightedReplace(t *testing.T) {
+	lb := loadbalance.NewDynamicWeighted[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins...)
+	updated := &myService{Address: ins[0].Address, Memory: 1}
+	if n := lb.Replace(updated, ins[2]); n != 1 || lb.Size() != 2 {
+		t.Fatalf("replaced %d instances, %d left", n, lb.Size())
+	}
+	// finish the round started before the replace, then every round follows the new weights
+	for i := 0; i < 10; i++ {
+		lb.Select()
+	}
+	m := map[string]int{}
+	for i := 0; i < 300; i++ {
+		m[lb.Select().Address]++
+	}
+	if m[ins[1].Address] != 0 || m[ins[0].Address] != 100 || m[ins[2].Address] != 200 {
+		t.Fatalf("got %v after replace, want the weights 1,2", m)
+	}
+	checkReplaceAtomic(t, loadbalance.NewDynamicWeighted[string, *myService]())
+}
+
+func TestDynamicWeightedUpdate(t *testing.T) {
+	dw := loadbalance.NewDynamicWeighted[string, *myService]()
+	ins := getInstance(1)
+	dw.Add(ins...)
+	dw.Select()
+	dw.Select()
+
+	// ins[0] already had one selection of this round, which is its whole new weight
+	updated := &myService{Address: ins[0].Address, Memory: 1}
+	if n := dw.Update(updated, &myService{Address: "unknown", Memory: 1}); n != 1 {
+		t.Fatalf("updated %d instances, want 1", n)
+	}
+	if got, _ := dw.Get(ins[0].Address); got != updated {
+		t.Fatal("the instance was not replaced")
+	}
+	counts := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		counts[dw.Select().Address]++
+	}
+	// the rest of this round is 2 + 2, the next round is 1 + 3 + 2
+	want := map[string]int{ins[0].Address: 1, ins[1].Address: 5, ins[2].Address: 4}
+	for addr, n := range want {
+		if counts[addr] != n {
+			t.Fatalf("%s was selected %d times, want %d: %v", addr, counts[addr], n, counts)
+		}
+	}
+
+	// a full round follows the new weights exactly
+	dw.Update(&myService{Address: ins[2].Address, Memory: 5})
+	counts = make(map[string]int)
+	for i := 0; i < 9; i++ {
+		counts[dw.Select().Address]++
+	}
+	want = map[string]int{ins[0].Address: 1, ins[1].Address: 3, ins[2].Address: 5}
+	for addr, n := range want {
+		if counts[addr] != n {
+			t.Fatalf("%s was selected %d times, want %d: %v", addr, counts[addr], n, counts)
+		}
+	}
+}
@@ -2,6 +2,17 @@ module github.com/ydmxcz/loadbalance
 
 go 1.19
 
-require github.com/alphadose/haxmap v1.2.0
+require (
+	github.com/alphadose/haxmap v1.2.0
+	google.golang.org/grpc v1.56.3
+)
 
-require golang.org/x/exp v0.0.0-20221031165847-c99f073a8326 // indirect
+require (
+	github.com/golang/protobuf v1.5.3 // indirect
+	golang.org/x/exp v0.0.0-20221031165847-c99f073a8326 // indirect
+	golang.org/x/net v0.9.0 // indirect
+	golang.org/x/sys v0.7.0 // indirect
+	golang.org/x/text v0.9.0 // indirect
+	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1 // indirect
+	google.golang.org/protobuf v1.30.0 // indirect
+)
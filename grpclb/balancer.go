@@ -0,0 +1,243 @@
+// Package grpclb backs the client-side load-balancing of gRPC with the load-balances of the package.
+//
+// A SubConn of gRPC is a instance of which the id is its address and the weight is set by `SetWeight`
+// in the attributes of the address, so the weighted load-balances work on gRPC the same as on HTTP.
+package grpclb
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+
+	"github.com/ydmxcz/loadbalance"
+)
+
+// SubConn is a ready SubConn of gRPC as a instance, the id of which is the address.
+// The load-balances given to this package must take `*SubConn` as I,
+// or a interface it implements such as `loadbalance.Instance[string]`.
+type SubConn struct {
+	balancer.SubConn
+	Address resolver.Address
+	weight  int
+}
+
+func (sc *SubConn) InstanceID() string {
+	return sc.Address.Addr
+}
+
+// InstanceWeight returns the weight set to the address by `SetWeight`
+func (sc *SubConn) InstanceWeight() int {
+	return sc.weight
+}
+
+// NewBuilder returns a `balancer.Builder` of the name to be registered by `balancer.Register`,
+// every ClientConn using it gets a new load-balance by newSelector.
+func NewBuilder[I loadbalance.Instance[string]](name string,
+	newSelector func() loadbalance.Selector[string, I]) balancer.Builder {
+	return &builder[I]{name: name, newPickerBuilder: func() *pickerBuilder[I] {
+		s := newSelector()
+		return newPickerBuilder[I](s, s, nil, "")
+	}}
+}
+
+// NewBuilderBy returns a `balancer.Builder` like `NewBuilder` for a SelectorBy such as `ConsistentHash`,
+// the key of a RPC is the first value of the outgoing metadata of the key.
+func NewBuilderBy[I loadbalance.Instance[string]](name, key string,
+	newSelector func() loadbalance.SelectorBy[string, I]) balancer.Builder {
+	return &builder[I]{name: name, newPickerBuilder: func() *pickerBuilder[I] {
+		s := newSelector()
+		return newPickerBuilder[I](s, nil, s, key)
+	}}
+}
+
+// NewPickerBuilder returns a `base.PickerBuilder` picking by the selector,
+// which must be used by one ClientConn only since it holds the SubConns of it.
+// The weights are read from the attributes of the addresses when the SubConns were created,
+// the balancers of `NewBuilder` update them on every resolver update.
+func NewPickerBuilder[I loadbalance.Instance[string]](selector loadbalance.Selector[string, I]) base.PickerBuilder {
+	return newPickerBuilder[I](selector, selector, nil, "")
+}
+
+// NewPickerBuilderBy returns a `base.PickerBuilder` like `NewPickerBuilder` for a SelectorBy,
+// the key of a RPC is the first value of the outgoing metadata of the key.
+func NewPickerBuilderBy[I loadbalance.Instance[string]](key string, selector loadbalance.SelectorBy[string, I]) base.PickerBuilder {
+	return newPickerBuilder[I](selector, nil, selector, key)
+}
+
+type builder[I loadbalance.Instance[string]] struct {
+	name             string
+	newPickerBuilder func() *pickerBuilder[I]
+}
+
+func (b *builder[I]) Name() string {
+	return b.name
+}
+
+func (b *builder[I]) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := b.newPickerBuilder()
+	return &weightedBalancer[I]{
+		Balancer: base.NewBalancerBuilder(b.name, pb, base.Config{}).Build(cc, opts),
+		pb:       pb,
+	}
+}
+
+// weightedBalancer is the balancer of the base package that keeps the weights up to date,
+// which only reads the attributes of the addresses when it creates the SubConns.
+type weightedBalancer[I loadbalance.Instance[string]] struct {
+	balancer.Balancer
+	pb *pickerBuilder[I]
+}
+
+func (b *weightedBalancer[I]) UpdateClientConnState(s balancer.ClientConnState) error {
+	b.pb.setWeights(s.ResolverState.Addresses)
+	return b.Balancer.UpdateClientConnState(s)
+}
+
+func (b *weightedBalancer[I]) ExitIdle() {
+	if ei, ok := b.Balancer.(balancer.ExitIdler); ok {
+		ei.ExitIdle()
+	}
+}
+
+type pickerBuilder[I loadbalance.Instance[string]] struct {
+	mutex      sync.Mutex
+	lb         loadbalance.Balancer[string, I]
+	selector   loadbalance.Selector[string, I]
+	selectorBy loadbalance.SelectorBy[string, I]
+	key        string
+	// weights are the latest weights of the addresses by the resolver
+	weights  map[string]int
+	subConns map[string]*SubConn
+}
+
+func newPickerBuilder[I loadbalance.Instance[string]](lb loadbalance.Balancer[string, I],
+	selector loadbalance.Selector[string, I], selectorBy loadbalance.SelectorBy[string, I], key string) *pickerBuilder[I] {
+	return &pickerBuilder[I]{
+		lb:         lb,
+		selector:   selector,
+		selectorBy: selectorBy,
+		key:        key,
+		subConns:   make(map[string]*SubConn),
+	}
+}
+
+func (pb *pickerBuilder[I]) setWeights(addrs []resolver.Address) {
+	weights := make(map[string]int, len(addrs))
+	for _, addr := range addrs {
+		weights[addr.Addr] = Weight(addr)
+	}
+	pb.mutex.Lock()
+	pb.weights = weights
+	pb.mutex.Unlock()
+}
+
+// Build replaces the instances of the load-balance with the ready SubConns,
+// so that the load-balance keeps its state of the SubConns that stay ready.
+func (pb *pickerBuilder[I]) Build(info base.PickerBuildInfo) balancer.Picker {
+	pb.mutex.Lock()
+	defer pb.mutex.Unlock()
+	subConns := make(map[string]*SubConn, len(info.ReadySCs))
+	instances := make([]I, 0, len(info.ReadySCs))
+	for sc, sci := range info.ReadySCs {
+		id := sci.Address.Addr
+		weight, ok := pb.weights[id]
+		if !ok {
+			weight = Weight(sci.Address)
+		}
+		n, ok := pb.subConns[id]
+		if ok && n.SubConn != sc {
+			// a new SubConn of the same address, the old one must not be kept
+			pb.lb.Del(any(n).(I))
+			ok = false
+		}
+		if !ok || n.weight != weight {
+			n = &SubConn{SubConn: sc, Address: sci.Address, weight: weight}
+		}
+		subConns[id] = n
+		instances = append(instances, any(n).(I))
+	}
+	pb.subConns = subConns
+	loadbalance.Replace(pb.lb, instances...)
+	return &picker[I]{
+		lb:         pb.lb,
+		selector:   pb.selector,
+		selectorBy: pb.selectorBy,
+		key:        pb.key,
+	}
+}
+
+type picker[I loadbalance.Instance[string]] struct {
+	lb         loadbalance.Balancer[string, I]
+	selector   loadbalance.Selector[string, I]
+	selectorBy loadbalance.SelectorBy[string, I]
+	key        string
+}
+
+func (p *picker[I]) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	ctx := info.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ins, err := p.pick(ctx)
+	if err != nil {
+		switch {
+		case errors.Is(err, loadbalance.ErrNoInstances):
+			// wait for a SubConn to be ready
+			return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+		case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+			return balancer.PickResult{}, status.FromContextError(err).Err()
+		}
+		return balancer.PickResult{}, status.Error(codes.Unavailable, err.Error())
+	}
+	start := time.Now()
+	return balancer.PickResult{
+		SubConn: any(ins).(*SubConn).SubConn,
+		Done: func(di balancer.DoneInfo) {
+			p.finish(ins, start, di.Err)
+		},
+	}, nil
+}
+
+func (p *picker[I]) pick(ctx context.Context) (I, error) {
+	if p.selectorBy != nil {
+		var key string
+		if md, ok := metadata.FromOutgoingContext(ctx); ok {
+			if values := md.Get(p.key); len(values) != 0 {
+				key = values[0]
+			}
+		}
+		return loadbalance.PickBy(ctx, p.selectorBy, key)
+	}
+	return loadbalance.Pick(ctx, p.selector)
+}
+
+// finish tells the load-balance how the RPC sent to the instance went,
+// only the errors of the connection or the server are failures of the instance.
+func (p *picker[I]) finish(ins I, start time.Time, err error) {
+	failed := false
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown, codes.DataLoss:
+		failed = true
+	}
+	if o, ok := p.lb.(loadbalance.Observer[string, I]); ok && !failed {
+		o.Observe(ins, time.Since(start))
+	}
+	if r, ok := p.lb.(loadbalance.Reporter[string, I]); ok {
+		if failed {
+			r.Report(ins, err)
+		} else {
+			r.Report(ins, nil)
+		}
+	}
+	if d, ok := p.lb.(loadbalance.LoadAware[string, I]); ok {
+		d.Done(ins)
+	}
+}
@@ -0,0 +1,226 @@
+package grpclb_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/ydmxcz/loadbalance"
+	"github.com/ydmxcz/loadbalance/grpclb"
+)
+
+// backends are gRPC servers on bufconn counting the RPCs they served
+type backends struct {
+	mutex     sync.Mutex
+	counts    map[string]int
+	listeners map[string]*bufconn.Listener
+}
+
+func startBackends(t *testing.T, addrs ...string) *backends {
+	b := &backends{counts: make(map[string]int), listeners: make(map[string]*bufconn.Listener)}
+	for _, addr := range addrs {
+		addr := addr
+		lis := bufconn.Listen(1 << 20)
+		srv := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{},
+			_ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			b.mutex.Lock()
+			b.counts[addr]++
+			b.mutex.Unlock()
+			return handler(ctx, req)
+		}))
+		healthpb.RegisterHealthServer(srv, health.NewServer())
+		go srv.Serve(lis)
+		t.Cleanup(srv.Stop)
+		b.listeners[addr] = lis
+	}
+	return b
+}
+
+func (b *backends) reset() map[string]int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	counts := b.counts
+	b.counts = make(map[string]int)
+	return counts
+}
+
+// dial the backends by the balancer with the weights of the addresses
+func (b *backends) dial(t *testing.T, builder balancer.Builder, weights map[string]int) healthpb.HealthClient {
+	// a scheme can not have "_"
+	r := manual.NewBuilderWithScheme(strings.ReplaceAll(builder.Name(), "_", "-"))
+	var addrs []resolver.Address
+	for addr, w := range weights {
+		addrs = append(addrs, grpclb.SetWeight(resolver.Address{Addr: addr}, w))
+	}
+	r.InitialState(resolver.State{Addresses: addrs})
+	return b.dialTarget(t, builder, r.Scheme()+":///backends", grpc.WithResolvers(r))
+}
+
+// dialTarget dials the backends by the balancer and waits for all of them to be ready
+func (b *backends) dialTarget(t *testing.T, builder balancer.Builder, target string,
+	opts ...grpc.DialOption) healthpb.HealthClient {
+	balancer.Register(builder)
+	conn, err := grpc.Dial(target, append(opts,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			lis, ok := b.listeners[addr]
+			if !ok {
+				return nil, fmt.Errorf("unknown address %q", addr)
+			}
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig":[{%q:{}}]}`, builder.Name())),
+	)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := healthpb.NewHealthClient(conn)
+	// wait for all the SubConns to be ready, the key of every call is different for the hash based ones
+	deadline := time.Now().Add(5 * time.Second)
+	for i := 0; ; i++ {
+		call(t, client, metadata.AppendToOutgoingContext(context.Background(), "user", fmt.Sprint(i)))
+		b.mutex.Lock()
+		n := len(b.counts)
+		b.mutex.Unlock()
+		if n == len(b.listeners) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the SubConns were not ready in time")
+		}
+	}
+	b.reset()
+	return client
+}
+
+func call(t *testing.T, client healthpb.HealthClient, ctx context.Context) {
+	if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		// it may be called by other goroutines
+		t.Error(err)
+	}
+}
+
+func TestDynamicWeighted(t *testing.T) {
+	b := startBackends(t, "a", "b", "c")
+	client := b.dial(t, grpclb.NewBuilder("test_dynamic_weighted",
+		func() loadbalance.Selector[string, *grpclb.SubConn] {
+			return loadbalance.NewDynamicWeighted[string, *grpclb.SubConn]()
+		}), map[string]int{"a": 5, "b": 3, "c": 2})
+
+	for i := 0; i < 1000; i++ {
+		call(t, client, context.Background())
+	}
+	counts := b.reset()
+	// the calls made while waiting for the SubConns may have been in the middle of a round
+	for addr, want := range map[string]int{"a": 500, "b": 300, "c": 200} {
+		if d := counts[addr] - want; d < -5 || d > 5 {
+			t.Fatalf("%s served %d calls, want %d: %v", addr, counts[addr], want, counts)
+		}
+	}
+}
+
+func TestConsistentHashByMetadata(t *testing.T) {
+	b := startBackends(t, "a", "b", "c", "d")
+	client := b.dial(t, grpclb.NewBuilderBy("test_consistent_hash", "user",
+		func() loadbalance.SelectorBy[string, loadbalance.Instance[string]] {
+			return loadbalance.NewConsistentHash[string]()
+		}), map[string]int{"a": 1, "b": 1, "c": 1, "d": 1})
+
+	served := make(map[string]struct{})
+	for i := 0; i < 20; i++ {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "user", fmt.Sprintf("user-%d", i))
+		for j := 0; j < 5; j++ {
+			call(t, client, ctx)
+		}
+		counts := b.reset()
+		if len(counts) != 1 {
+			t.Fatalf("the calls of a user were served by %v", counts)
+		}
+		for addr := range counts {
+			served[addr] = struct{}{}
+		}
+	}
+	if len(served) < 2 {
+		t.Fatalf("20 users were all served by %v", served)
+	}
+}
+
+func TestP2C(t *testing.T) {
+	b := startBackends(t, "a", "b", "c")
+	var pc *loadbalance.P2C[string, *grpclb.SubConn]
+	client := b.dial(t, grpclb.NewBuilder("test_p2c",
+		func() loadbalance.Selector[string, *grpclb.SubConn] {
+			pc = loadbalance.NewP2C[string, *grpclb.SubConn]()
+			return pc
+		}), map[string]int{"a": 1, "b": 1, "c": 1})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				call(t, client, context.Background())
+			}
+		}()
+	}
+	wg.Wait()
+	if counts := b.reset(); len(counts) != 3 {
+		t.Fatalf("the calls were served by %v", counts)
+	}
+	// every RPC is done, nothing is outstanding
+	pc.ForEach(func(addr string, _ *grpclb.SubConn) bool {
+		if n := pc.Inflight(addr); n != 0 {
+			t.Fatalf("%s has %d outstanding RPCs", addr, n)
+		}
+		return true
+	})
+}
+
+// the wrappers pass `Done` to the P2C behind them
+func TestWrappedP2C(t *testing.T) {
+	wrappers := map[string]func(loadbalance.Selector[string, *grpclb.SubConn]) loadbalance.Selector[string, *grpclb.SubConn]{
+		"circuit_breaker": func(s loadbalance.Selector[string, *grpclb.SubConn]) loadbalance.Selector[string, *grpclb.SubConn] {
+			return loadbalance.NewCircuitBreaker[string, *grpclb.SubConn](s)
+		},
+		"outlier_detector": func(s loadbalance.Selector[string, *grpclb.SubConn]) loadbalance.Selector[string, *grpclb.SubConn] {
+			return loadbalance.NewOutlierDetector[string, *grpclb.SubConn](s)
+		},
+	}
+	for name, wrap := range wrappers {
+		t.Run(name, func(t *testing.T) {
+			b := startBackends(t, "a", "b", "c")
+			var pc *loadbalance.P2C[string, *grpclb.SubConn]
+			client := b.dial(t, grpclb.NewBuilder("test_p2c_"+name,
+				func() loadbalance.Selector[string, *grpclb.SubConn] {
+					pc = loadbalance.NewP2C[string, *grpclb.SubConn]()
+					return wrap(pc)
+				}), map[string]int{"a": 1, "b": 1, "c": 1})
+
+			for i := 0; i < 30; i++ {
+				call(t, client, context.Background())
+			}
+			pc.ForEach(func(addr string, _ *grpclb.SubConn) bool {
+				if n := pc.Inflight(addr); n != 0 {
+					t.Fatalf("%s has %d outstanding RPCs", addr, n)
+				}
+				return true
+			})
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package grpclb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// Scheme is the scheme of the targets resolved by the resolver of `NewResolverBuilder`,
+// e.g. "lb://orders" for the service "orders".
+const Scheme = "lb"
+
+// Endpoint is a address of a service and its weight, a weight not positive is 1.
+type Endpoint struct {
+	Addr   string `json:"addr"`
+	Weight int    `json:"weight"`
+}
+
+// Source gives the endpoints of the services by name
+type Source interface {
+	// Watch calls update with the endpoints of the service at once and every time they change,
+	// or with the error reading them, until stop is called.
+	// update is never called once stop has returned, so update must not call stop.
+	Watch(name string, update func([]Endpoint, error)) (stop func())
+}
+
+// NewResolverBuilder returns a `resolver.Builder` of the scheme "lb" to be registered by `resolver.Register`
+// or given by `grpc.WithResolvers`, it resolves the target "lb://name" to the endpoints of the service
+// from the source, with the weights in the attributes of the addresses like `SetWeight`.
+func NewResolverBuilder(source Source) resolver.Builder {
+	return &resolverBuilder{source: source}
+}
+
+type resolverBuilder struct {
+	source Source
+}
+
+func (b *resolverBuilder) Scheme() string {
+	return Scheme
+}
+
+func (b *resolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	// both "lb://name" and "lb:///name"
+	name := target.URL.Host
+	if name == "" {
+		name = target.Endpoint()
+	}
+	if name == "" {
+		return nil, errors.New("grpclb: no service name in the target " + target.URL.String())
+	}
+	r := &sourceResolver{cc: cc}
+	r.stop = b.source.Watch(name, r.update)
+	return r, nil
+}
+
+// sourceResolver pushes the endpoints of a service to the ClientConn
+type sourceResolver struct {
+	cc   resolver.ClientConn
+	stop func()
+}
+
+func (r *sourceResolver) update(endpoints []Endpoint, err error) {
+	if err != nil {
+		r.cc.ReportError(err)
+		return
+	}
+	addrs := make([]resolver.Address, 0, len(endpoints))
+	for _, e := range endpoints {
+		addr := resolver.Address{Addr: e.Addr}
+		if e.Weight > 0 {
+			addr = SetWeight(addr, e.Weight)
+		}
+		addrs = append(addrs, addr)
+	}
+	r.cc.UpdateState(resolver.State{Addresses: addrs})
+}
+
+// ResolveNow does nothing since the source tells every change
+func (r *sourceResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (r *sourceResolver) Close() {
+	r.stop()
+}
+
+// StaticSource is a Source of the endpoints set by `Set`
+type StaticSource struct {
+	mutex     sync.Mutex
+	endpoints map[string][]Endpoint
+	watchers  map[string]map[*staticWatcher]struct{}
+	// version orders the endpoints told to the watchers
+	version uint64
+}
+
+func NewStaticSource() *StaticSource {
+	return &StaticSource{
+		endpoints: make(map[string][]Endpoint),
+		watchers:  make(map[string]map[*staticWatcher]struct{}),
+	}
+}
+
+// Set the endpoints of the service and tell the watchers of it.
+// The watchers are called without the lock of the source, so they can call the source themselves.
+func (s *StaticSource) Set(name string, endpoints ...Endpoint) {
+	s.mutex.Lock()
+	s.endpoints[name] = endpoints
+	s.version++
+	version := s.version
+	watchers := make([]*staticWatcher, 0, len(s.watchers[name]))
+	for w := range s.watchers[name] {
+		watchers = append(watchers, w)
+	}
+	s.mutex.Unlock()
+	for _, w := range watchers {
+		w.tell(version, endpoints)
+	}
+}
+
+func (s *StaticSource) Watch(name string, update func([]Endpoint, error)) (stop func()) {
+	w := &staticWatcher{update: update}
+	s.mutex.Lock()
+	if s.watchers[name] == nil {
+		s.watchers[name] = make(map[*staticWatcher]struct{})
+	}
+	s.watchers[name][w] = struct{}{}
+	s.version++
+	version, endpoints := s.version, s.endpoints[name]
+	s.mutex.Unlock()
+	w.tell(version, endpoints)
+	return func() {
+		s.mutex.Lock()
+		delete(s.watchers[name], w)
+		s.mutex.Unlock()
+		w.stop()
+	}
+}
+
+// staticWatcher is a watcher of a StaticSource, which is told the endpoints of every `Set` in order:
+// the endpoints of a `Set` told after the ones of a later `Set` are dropped.
+type staticWatcher struct {
+	mutex   sync.Mutex
+	update  func([]Endpoint, error)
+	version uint64
+	stopped bool
+}
+
+func (w *staticWatcher) tell(version uint64, endpoints []Endpoint) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.stopped || version <= w.version {
+		return
+	}
+	w.version = version
+	w.update(endpoints, nil)
+}
+
+// stop waits for the update being told and drops the later ones
+func (w *staticWatcher) stop() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.stopped = true
+}
+
+// FileSource is a Source of the endpoints in a JSON file of the services by name, e.g.
+//
+//	{"orders": [{"addr": "10.0.0.1:8080", "weight": 5}, {"addr": "10.0.0.2:8080", "weight": 3}]}
+//
+// The file is read again every interval and the watchers are told when it changed.
+type FileSource struct {
+	path     string
+	interval time.Duration
+}
+
+// NewFileSource returns a FileSource of the file,
+// the optional argument is the interval of reading it, 5 seconds by default.
+func NewFileSource(path string, interval ...time.Duration) *FileSource {
+	fs := &FileSource{path: path, interval: 5 * time.Second}
+	if len(interval) != 0 && interval[0] > 0 {
+		fs.interval = interval[0]
+	}
+	return fs
+}
+
+func (fs *FileSource) read() ([]byte, map[string][]Endpoint, error) {
+	data, err := os.ReadFile(fs.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var services map[string][]Endpoint
+	if err = json.Unmarshal(data, &services); err != nil {
+		return nil, nil, err
+	}
+	return data, services, nil
+}
+
+func (fs *FileSource) Watch(name string, update func([]Endpoint, error)) (stop func()) {
+	last, services, err := fs.read()
+	update(services[name], err)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(fs.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			data, services, err := fs.read()
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err != nil {
+				// tell the error once, the endpoints are told again once the file is fine
+				if last != nil {
+					update(nil, err)
+				}
+				last = nil
+				continue
+			}
+			if !bytes.Equal(data, last) {
+				last = data
+				update(services[name], nil)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+		// wait for the update being told
+		wg.Wait()
+	}
+}
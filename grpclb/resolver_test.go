@@ -0,0 +1,189 @@
+package grpclb_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/ydmxcz/loadbalance"
+	"github.com/ydmxcz/loadbalance/grpclb"
+)
+
+func TestResolver(t *testing.T) {
+	b := startBackends(t, "a", "b", "c")
+	src := grpclb.NewStaticSource()
+	src.Set("orders",
+		grpclb.Endpoint{Addr: "a", Weight: 5},
+		grpclb.Endpoint{Addr: "b", Weight: 3},
+		grpclb.Endpoint{Addr: "c", Weight: 2})
+	client := b.dialTarget(t, grpclb.NewBuilder("test_resolver",
+		func() loadbalance.Selector[string, *grpclb.SubConn] {
+			return loadbalance.NewDynamicWeighted[string, *grpclb.SubConn]()
+		}), "lb://orders", grpc.WithResolvers(grpclb.NewResolverBuilder(src)))
+
+	for i := 0; i < 1000; i++ {
+		call(t, client, context.Background())
+	}
+	counts := b.reset()
+	for addr, want := range map[string]int{"a": 500, "b": 300, "c": 200} {
+		if d := counts[addr] - want; d < -5 || d > 5 {
+			t.Fatalf("%s served %d calls, want %d: %v", addr, counts[addr], want, counts)
+		}
+	}
+
+	// the new weights apply to the SubConns already there
+	src.Set("orders", grpclb.Endpoint{Addr: "a", Weight: 1}, grpclb.Endpoint{Addr: "b", Weight: 9})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.reset()
+		for i := 0; i < 100; i++ {
+			call(t, client, context.Background())
+		}
+		counts = b.reset()
+		if counts["c"] == 0 && counts["a"] >= 8 && counts["a"] <= 12 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the calls were served by %v after the update", counts)
+		}
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.json")
+	// rename the file written so that it is never read half written
+	write := func(data string) {
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"orders": [{"addr": "a", "weight": 5}, {"addr": "b"}], "users": [{"addr": "c"}]}`)
+
+	type update struct {
+		endpoints []grpclb.Endpoint
+		err       error
+	}
+	updates := make(chan update, 8)
+	stop := grpclb.NewFileSource(path, 10*time.Millisecond).Watch("orders",
+		func(endpoints []grpclb.Endpoint, err error) {
+			updates <- update{endpoints, err}
+		})
+	defer stop()
+
+	next := func() []grpclb.Endpoint {
+		select {
+		case u := <-updates:
+			if u.err != nil {
+				t.Fatal(u.err)
+			}
+			return u.endpoints
+		case <-time.After(time.Second):
+			t.Fatal("no update in time")
+			return nil
+		}
+	}
+	if got := next(); len(got) != 2 || got[0] != (grpclb.Endpoint{Addr: "a", Weight: 5}) {
+		t.Fatalf("got %v at first", got)
+	}
+	write(`{"orders": [{"addr": "b", "weight": 2}]}`)
+	if got := next(); len(got) != 1 || got[0] != (grpclb.Endpoint{Addr: "b", Weight: 2}) {
+		t.Fatalf("got %v after the file changed", got)
+	}
+	select {
+	case u := <-updates:
+		t.Fatalf("got %v, %v while the file did not change", u.endpoints, u.err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestStaticSource(t *testing.T) {
+	src := grpclb.NewStaticSource()
+	var mutex sync.Mutex
+	var told [][]grpclb.Endpoint
+	stop := src.Watch("orders", func(endpoints []grpclb.Endpoint, err error) {
+		// the watchers are called without the lock of the source
+		src.Set("users", endpoints...)
+		mutex.Lock()
+		defer mutex.Unlock()
+		told = append(told, endpoints)
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			src.Set("orders", grpclb.Endpoint{Addr: fmt.Sprint(i)})
+		}(i)
+	}
+	wg.Wait()
+	// the last endpoints told are the ones set last, whatever order the watcher was called in
+	var last []grpclb.Endpoint
+	src.Watch("orders", func(endpoints []grpclb.Endpoint, _ error) { last = endpoints })()
+	mutex.Lock()
+	if got := told[len(told)-1]; len(got) != 1 || got[0] != last[0] {
+		t.Fatalf("told %v at last, want %v", got, last)
+	}
+	n := len(told)
+	mutex.Unlock()
+
+	stop()
+	src.Set("orders")
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(told) != n {
+		t.Fatalf("told %v after stop", told[n:])
+	}
+}
+
+func TestFileSourceStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.json")
+	if err := os.WriteFile(path, []byte(`{"orders": [{"addr": "a"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var calls int32
+	entered, release := make(chan struct{}), make(chan struct{})
+	stop := grpclb.NewFileSource(path, time.Millisecond).Watch("orders",
+		func([]grpclb.Endpoint, error) {
+			// block in the update after the first one
+			if atomic.AddInt32(&calls, 1) == 2 {
+				close(entered)
+				<-release
+			}
+		})
+	if err := os.WriteFile(path, []byte(`{}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	<-entered
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		close(stopped)
+	}()
+	// stop waits for the update being told
+	select {
+	case <-stopped:
+		t.Fatal("stop returned while a update was being told")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	<-stopped
+	if err := os.WriteFile(path, []byte(`{"orders": [{"addr": "b"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("updated %d times, want no update after stop", n)
+	}
+}
@@ -0,0 +1,22 @@
+package grpclb
+
+import (
+	"google.golang.org/grpc/resolver"
+)
+
+type weightKey struct{}
+
+// SetWeight returns the address with the weight in its balancer attributes,
+// which is the weight of the SubConn of the address.
+func SetWeight(addr resolver.Address, weight int) resolver.Address {
+	addr.BalancerAttributes = addr.BalancerAttributes.WithValue(weightKey{}, weight)
+	return addr
+}
+
+// Weight returns the weight of the address set by `SetWeight`, or 1 if it has none
+func Weight(addr resolver.Address) int {
+	if w, ok := addr.BalancerAttributes.Value(weightKey{}).(int); ok {
+		return w
+	}
+	return 1
+}
@@ -59,7 +59,7 @@ type Hashable interface {
 }
 
 type stringHeader struct {
-	Data uintptr
+	Data unsafe.Pointer
 	Len  int
 }
 
@@ -197,7 +197,7 @@ var (
 	}
 	stringHasher = func(key string) uint64 {
 		sh := (*stringHeader)(unsafe.Pointer(&key))
-		b := unsafe.Slice((*byte)(unsafe.Pointer(sh.Data)), sh.Len)
+		b := unsafe.Slice((*byte)(sh.Data), sh.Len)
 		n := sh.Len
 		var h uint64
 
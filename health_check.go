@@ -0,0 +1,359 @@
+package loadbalance
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Checker probes a instance and returns nil if it is healthy
+type Checker[T Hashable, I Instance[T]] func(ctx context.Context, ins I) error
+
+// address returns the address of instance by the function,
+// or the id of instance if the function is nil.
+func address[T Hashable, I Instance[T]](ins I, addr func(I) string) string {
+	if addr != nil {
+		return addr(ins)
+	}
+	return fmt.Sprint(ins.InstanceID())
+}
+
+// TCPCheck returns a Checker that connects to the instance,
+// `addr` returns the "host:port" of instance, nil means the id of instance is the address.
+func TCPCheck[T Hashable, I Instance[T]](addr func(I) string) Checker[T, I] {
+	var dialer net.Dialer
+	return func(ctx context.Context, ins I) error {
+		conn, err := dialer.DialContext(ctx, "tcp", address[T](ins, addr))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// HTTPCheck returns a Checker that sends a GET request to the instance
+// and regards any 2xx status as healthy,
+// `url` returns the url to get, nil means "http://" + the id of instance + "/".
+// A nil client means `http.DefaultClient`.
+func HTTPCheck[T Hashable, I Instance[T]](url func(I) string, client *http.Client) Checker[T, I] {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if url == nil {
+		url = func(ins I) string {
+			return "http://" + fmt.Sprint(ins.InstanceID()) + "/"
+		}
+	}
+	return func(ctx context.Context, ins I) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url(ins), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		// read the body to the end so that the connection is kept alive for the next check
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("loadbalance: health check of %v got status %s", ins.InstanceID(), resp.Status)
+		}
+		return nil
+	}
+}
+
+// HealthCheckConfig is the config of HealthChecker,
+// the zero value of a field means its default value.
+type HealthCheckConfig struct {
+	// Interval between two rounds of check, 10 seconds by default
+	Interval time.Duration
+	// Timeout of a single check, 3 seconds by default
+	Timeout time.Duration
+	// HealthyThreshold is the number of consecutive successful checks
+	// before a unhealthy instance is added back, 2 by default
+	HealthyThreshold int
+	// UnhealthyThreshold is the number of consecutive failed checks
+	// before a healthy instance is deleted, 3 by default
+	UnhealthyThreshold int
+}
+
+func (hc *HealthCheckConfig) setDefault() {
+	if hc.Interval <= 0 {
+		hc.Interval = 10 * time.Second
+	}
+	if hc.Timeout <= 0 {
+		hc.Timeout = 3 * time.Second
+	}
+	if hc.HealthyThreshold <= 0 {
+		hc.HealthyThreshold = 2
+	}
+	if hc.UnhealthyThreshold <= 0 {
+		hc.UnhealthyThreshold = 3
+	}
+}
+
+// `healthState` is a registered instance and the result of its recent checks
+type healthState[T Hashable, I Instance[T]] struct {
+	instance  I
+	healthy   bool
+	successes int
+	failures  int
+}
+
+// HealthChecker actively checks instances and keeps only the healthy ones
+// in the target load-balance, which can be any `Selector` or `SelectorBy`.
+//
+// Instances are added to and deleted from the HealthChecker instead of the target,
+// the checker keeps all of them in its own registry
+// because the target no longer knows a instance once it was deleted.
+// A instance is deleted from the target after `UnhealthyThreshold` consecutive failed checks
+// and added back after `HealthyThreshold` consecutive successful checks.
+// New instances are regarded as healthy until they fail.
+type HealthChecker[T Hashable, I Instance[T]] struct {
+	mutex    sync.Mutex
+	target   Balancer[T, I]
+	check    Checker[T, I]
+	config   HealthCheckConfig
+	registry map[T]*healthState[T, I]
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func NewHealthChecker[T Hashable, I Instance[T]](target Balancer[T, I], check Checker[T, I],
+	config ...HealthCheckConfig) *HealthChecker[T, I] {
+	var c HealthCheckConfig
+	if len(config) != 0 {
+		c = config[0]
+	}
+	c.setDefault()
+	return &HealthChecker[T, I]{
+		target:   target,
+		check:    check,
+		config:   c,
+		registry: make(map[T]*healthState[T, I]),
+	}
+}
+
+// Add registers some instances and adds them to the target,
+// return the number of successful operation
+func (hc *HealthChecker[T, I]) Add(instances ...I) int {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	count := 0
+	for _, instance := range instances {
+		id := instance.InstanceID()
+		if _, ok := hc.registry[id]; !ok {
+			hc.registry[id] = &healthState[T, I]{instance: instance, healthy: true}
+			hc.target.Add(instance)
+			count++
+		}
+	}
+	return count
+}
+
+// Del unregisters some instances and deletes them from the target,
+// return the number of successful operation
+func (hc *HealthChecker[T, I]) Del(instances ...I) int {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	count := 0
+	for _, instance := range instances {
+		id := instance.InstanceID()
+		if s, ok := hc.registry[id]; ok {
+			delete(hc.registry, id)
+			if s.healthy {
+				hc.target.Del(s.instance)
+			}
+			count++
+		}
+	}
+	return count
+}
+
+// Replace the registered instances with the new set in one step
+// and return the number of instances added or deleted.
+// The instances kept keep their health, new instances are regarded as healthy,
+// the target is replaced with the healthy instances.
+func (hc *HealthChecker[T, I]) Replace(instances ...I) int {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	added, kept, set := splitReplace(instances, func(id T) bool {
+		_, ok := hc.registry[id]
+		return ok
+	})
+	count := len(added)
+	for id := range hc.registry {
+		if _, ok := set[id]; !ok {
+			delete(hc.registry, id)
+			count++
+		}
+	}
+	healthy := make([]I, 0, len(set))
+	for _, instance := range kept {
+		// a new state, the instance of a state being checked is never written
+		old := hc.registry[instance.InstanceID()]
+		s := *old
+		s.instance = instance
+		hc.registry[instance.InstanceID()] = &s
+		if s.healthy {
+			healthy = append(healthy, instance)
+		}
+	}
+	for _, instance := range added {
+		hc.registry[instance.InstanceID()] = &healthState[T, I]{instance: instance, healthy: true}
+		healthy = append(healthy, instance)
+	}
+	Replace[T, I](hc.target, healthy...)
+	return count
+}
+
+// Get a registered instance whether it is healthy or not
+func (hc *HealthChecker[T, I]) Get(key T) (ins I, ok bool) {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	if s, ok := hc.registry[key]; ok {
+		return s.instance, true
+	}
+	return
+}
+
+// ForEach every registered instances whether it is healthy or not
+func (hc *HealthChecker[T, I]) ForEach(callback func(T, I) bool) {
+	hc.mutex.Lock()
+	states := make([]*healthState[T, I], 0, len(hc.registry))
+	for _, s := range hc.registry {
+		states = append(states, s)
+	}
+	hc.mutex.Unlock()
+	for _, s := range states {
+		if !callback(s.instance.InstanceID(), s.instance) {
+			return
+		}
+	}
+}
+
+// Size returns the number of registered instances
+func (hc *HealthChecker[T, I]) Size() int {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	return len(hc.registry)
+}
+
+// Healthy returns whether the instance is registered and healthy
+func (hc *HealthChecker[T, I]) Healthy(key T) bool {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	s, ok := hc.registry[key]
+	return ok && s.healthy
+}
+
+// Pick selects a instance from the target, which must be a Selector.
+// It returns `ErrAllUnhealthy` if every registered instance is unhealthy
+// and `ErrNoInstances` if there is none.
+func (hc *HealthChecker[T, I]) Pick(ctx context.Context) (I, error) {
+	s, ok := hc.target.(Selector[T, I])
+	if !ok {
+		return *new(I), fmt.Errorf("loadbalance: %T is not a Selector", hc.target)
+	}
+	return pick(ctx, func() (I, bool) { return selectExcluding(s, nil) }, hc.Size)
+}
+
+// PickBy selects a instance for the key from the target, which must be a SelectorBy.
+// It returns the same errors as `Pick`.
+func (hc *HealthChecker[T, I]) PickBy(ctx context.Context, key string) (I, error) {
+	s, ok := hc.target.(SelectorBy[T, I])
+	if !ok {
+		return *new(I), fmt.Errorf("loadbalance: %T is not a SelectorBy", hc.target)
+	}
+	return pick(ctx, func() (I, bool) { return selectByExcluding(s, key, nil) }, hc.Size)
+}
+
+// Start checks all registered instances every interval in a new goroutine until `Stop`.
+func (hc *HealthChecker[T, I]) Start() {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	if hc.stop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	hc.stop = stop
+	hc.wg.Add(1)
+	go func() {
+		defer hc.wg.Done()
+		ticker := time.NewTicker(hc.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				hc.CheckNow(context.Background())
+			}
+		}
+	}()
+}
+
+// Stop the goroutine started by `Start` and wait for it
+func (hc *HealthChecker[T, I]) Stop() {
+	hc.mutex.Lock()
+	stop := hc.stop
+	hc.stop = nil
+	hc.mutex.Unlock()
+	if stop != nil {
+		close(stop)
+		hc.wg.Wait()
+	}
+}
+
+// CheckNow checks all registered instances concurrently once,
+// and returns after the instances crossed a threshold were deleted from or added to the target.
+func (hc *HealthChecker[T, I]) CheckNow(ctx context.Context) {
+	hc.mutex.Lock()
+	states := make([]*healthState[T, I], 0, len(hc.registry))
+	for _, s := range hc.registry {
+		states = append(states, s)
+	}
+	hc.mutex.Unlock()
+
+	results := make([]error, len(states))
+	var wg sync.WaitGroup
+	for i, s := range states {
+		wg.Add(1)
+		go func(i int, ins I) {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx, hc.config.Timeout)
+			defer cancel()
+			results[i] = hc.check(cctx, ins)
+		}(i, s.instance)
+	}
+	wg.Wait()
+
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	for i, s := range states {
+		// deleted or replaced while checking
+		if hc.registry[s.instance.InstanceID()] != s {
+			continue
+		}
+		if results[i] == nil {
+			s.failures = 0
+			s.successes++
+			if !s.healthy && s.successes >= hc.config.HealthyThreshold {
+				s.healthy = true
+				hc.target.Add(s.instance)
+			}
+		} else {
+			s.successes = 0
+			s.failures++
+			if s.healthy && s.failures >= hc.config.UnhealthyThreshold {
+				s.healthy = false
+				hc.target.Del(s.instance)
+			}
+		}
+	}
+}
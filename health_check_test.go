@@ -0,0 +1,199 @@
+package loadbalance_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ydmxcz/loadbalance"
+)
+
+func TestHealthCheckerThresholds(t *testing.T) {
+	var mutex sync.Mutex
+	down := map[string]bool{}
+	check := func(_ context.Context, ins *myService) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if down[ins.Address] {
+			return errors.New("down")
+		}
+		return nil
+	}
+	setDown := func(addr string, d bool) {
+		mutex.Lock()
+		down[addr] = d
+		mutex.Unlock()
+	}
+
+	lb := loadbalance.NewRoundRobin[string, *myService]()
+	hc := loadbalance.NewHealthChecker[string, *myService](lb, check, loadbalance.HealthCheckConfig{
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 2,
+	})
+	ins := getInstance(1)
+	hc.Add(ins...)
+	if lb.Size() != 3 {
+		t.Fatal("new instances were not added to the target")
+	}
+
+	setDown(ins[0].Address, true)
+	hc.CheckNow(context.Background())
+	if lb.Size() != 3 {
+		t.Fatal("deleted before reaching the unhealthy threshold")
+	}
+	hc.CheckNow(context.Background())
+	if _, ok := lb.Get(ins[0].Address); ok || hc.Healthy(ins[0].Address) {
+		t.Fatal("unhealthy instance is still in the target")
+	}
+	if _, ok := hc.Get(ins[0].Address); !ok || hc.Size() != 3 {
+		t.Fatal("unhealthy instance was dropped from the registry")
+	}
+
+	setDown(ins[0].Address, false)
+	hc.CheckNow(context.Background())
+	hc.CheckNow(context.Background())
+	if _, ok := lb.Get(ins[0].Address); !ok {
+		t.Fatal("recovered instance was not added back")
+	}
+
+	hc.Del(ins[1])
+	if lb.Size() != 2 || hc.Size() != 2 {
+		t.Fatal("delete failed")
+	}
+}
+
+func TestHealthCheckerTCPAndHTTP(t *testing.T) {
+	var status = http.StatusOK
+	var mutex sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	// a address nobody listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().String()
+	l.Close()
+
+	alive := &myService{Address: strings.TrimPrefix(srv.URL, "http://"), Memory: 1}
+	dead := &myService{Address: closed, Memory: 1}
+
+	lb := loadbalance.NewRandom[string, *myService]()
+	tcp := loadbalance.NewHealthChecker[string, *myService](lb, loadbalance.TCPCheck[string, *myService](nil),
+		loadbalance.HealthCheckConfig{UnhealthyThreshold: 1, Timeout: time.Second})
+	tcp.Add(alive, dead)
+	tcp.CheckNow(context.Background())
+	if !tcp.Healthy(alive.Address) || tcp.Healthy(dead.Address) || lb.Size() != 1 {
+		t.Fatal("tcp check got wrong result")
+	}
+
+	lb2 := loadbalance.NewRandom[string, *myService]()
+	hc := loadbalance.NewHealthChecker[string, *myService](lb2, loadbalance.HTTPCheck[string, *myService](nil, nil),
+		loadbalance.HealthCheckConfig{UnhealthyThreshold: 1, Interval: 10 * time.Millisecond})
+	hc.Add(alive)
+	mutex.Lock()
+	status = http.StatusServiceUnavailable
+	mutex.Unlock()
+	hc.Start()
+	defer hc.Stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for lb2.Size() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("http check did not delete the failing instance")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHTTPCheckKeepAlive(t *testing.T) {
+	var mutex sync.Mutex
+	conns := 0
+	// a body too large for the transport to drain by itself when it is closed
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("ok", 2<<20)))
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mutex.Lock()
+			conns++
+			mutex.Unlock()
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	check := loadbalance.HTTPCheck[string, *myService](nil, srv.Client())
+	ins := &myService{Address: strings.TrimPrefix(srv.URL, "http://"), Memory: 1}
+	for i := 0; i < 5; i++ {
+		if err := check(context.Background(), ins); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if conns != 1 {
+		t.Fatalf("5 checks opened %d connections", conns)
+	}
+}
+
+func TestHealthCheckerPick(t *testing.T) {
+	errFailed := errors.New("failed")
+	hc := loadbalance.NewHealthChecker[string, *myService](loadbalance.NewRandom[string, *myService](),
+		func(context.Context, *myService) error { return errFailed },
+		loadbalance.HealthCheckConfig{UnhealthyThreshold: 1})
+	if _, err := hc.Pick(context.Background()); !errors.Is(err, loadbalance.ErrNoInstances) {
+		t.Fatalf("got %v, want ErrNoInstances", err)
+	}
+	hc.Add(getInstance(1)...)
+	if _, err := hc.Pick(context.Background()); err != nil {
+		t.Fatalf("got %v before checks", err)
+	}
+	// the unhealthy instances are deleted from the target but still registered
+	hc.CheckNow(context.Background())
+	if _, err := hc.Pick(context.Background()); !errors.Is(err, loadbalance.ErrAllUnhealthy) {
+		t.Fatalf("got %v, want ErrAllUnhealthy", err)
+	}
+	if _, err := hc.PickBy(context.Background(), "key"); err == nil {
+		t.Fatal("PickBy on a Selector succeeded")
+	}
+}
+
+func TestHealthCheckerReplace(t *testing.T) {
+	errFailed := errors.New("failed")
+	ins := getInstance(1)
+	hc := loadbalance.NewHealthChecker[string, *myService](loadbalance.NewRoundRobin[string, *myService](),
+		func(_ context.Context, in *myService) error {
+			if in.Address == ins[0].Address {
+				return errFailed
+			}
+			return nil
+		},
+		loadbalance.HealthCheckConfig{UnhealthyThreshold: 1})
+	hc.Add(ins[0], ins[1])
+	hc.CheckNow(context.Background())
+	if n := hc.Replace(ins...); n != 1 || hc.Size() != 3 {
+		t.Fatalf("replaced %d instances, %d registered", n, hc.Size())
+	}
+	// the kept unhealthy instance stays unhealthy and the new one is regarded as healthy
+	if hc.Healthy(ins[0].Address) {
+		t.Fatal("a kept unhealthy instance became healthy")
+	}
+	if !hc.Healthy(ins[2].Address) {
+		t.Fatal("a new instance is not healthy")
+	}
+	for i := 0; i < 100; i++ {
+		if sel, err := hc.Pick(context.Background()); err != nil || sel == ins[0] {
+			t.Fatalf("picked %v, %v", sel, err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Package httpproxy puts the load-balances of the package in front of HTTP servers,
+// as a reverse proxy serving HTTP or as a `http.RoundTripper` of the clients.
+package httpproxy
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/ydmxcz/loadbalance"
+)
+
+// Config is the optional config of a Proxy, the zero value of every field is the default.
+type Config[T loadbalance.Hashable, I loadbalance.Instance[T]] struct {
+	// Target returns the URL of the instance, of which the scheme and host are used.
+	// By default it is the id of the instance, with the scheme "http" if it has none.
+	Target func(I) (*url.URL, error)
+	// MaxRetries is the number of other instances a idempotent request is sent to
+	// when the connection fails, 2 by default and no retry if it is negative.
+	MaxRetries int
+	// Transport sends the requests, `http.DefaultTransport` by default
+	Transport http.RoundTripper
+	// ErrorHandler replies to the request that failed on every instance,
+	// by default it replies 503 if there is no instance to select, or 502 otherwise.
+	ErrorHandler func(http.ResponseWriter, *http.Request, error)
+	// ErrorLog is passed to the `httputil.ReverseProxy`
+	ErrorLog *log.Logger
+}
+
+// Proxy is a `http.Handler` that sends every request to a instance of the selector
+// by a `httputil.ReverseProxy`.
+//
+// A idempotent request is retried on another instance when the connection fails.
+// How every request went is told to the selector if it has the methods:
+// `Done(I)` once the response is finished, `Observe(I, time.Duration)` with the latency till the response header,
+// and `Report(I, error)` with the error of the connection or the status 5xx.
+// Only the selector given is told, the wrappers of the package such as `loadbalance.CircuitBreaker`
+// and `loadbalance.OutlierDetector` pass `Done` and `Observe` to their target,
+// so e.g. a `loadbalance.P2C` behind a breaker still counts the outstanding requests.
+type Proxy[T loadbalance.Hashable, I loadbalance.Instance[T]] struct {
+	config Config[T, I]
+	proxy  *httputil.ReverseProxy
+}
+
+// New returns a Proxy in front of the selector
+func New[T loadbalance.Hashable, I loadbalance.Instance[T]](selector loadbalance.Selector[T, I],
+	config ...Config[T, I]) *Proxy[T, I] {
+	p := &Proxy[T, I]{config: withDefaults(config)}
+	s := &service[T, I]{lb: selector, config: &p.config, selector: selector}
+	p.proxy = &httputil.ReverseProxy{
+		// the request is rewritten to the instance selected by the transport
+		Director:     func(*http.Request) {},
+		Transport:    roundTripperFunc(s.roundTrip),
+		ErrorHandler: p.config.ErrorHandler,
+		ErrorLog:     p.config.ErrorLog,
+	}
+	return p
+}
+
+// withDefaults returns the optional config with the default of every zero field
+func withDefaults[T loadbalance.Hashable, I loadbalance.Instance[T]](config []Config[T, I]) (c Config[T, I]) {
+	if len(config) != 0 {
+		c = config[0]
+	}
+	if c.Target == nil {
+		c.Target = instanceURL[T, I]
+	}
+	if c.MaxRetries == 0 {
+		c.MaxRetries = 2
+	}
+	if c.Transport == nil {
+		c.Transport = http.DefaultTransport
+	}
+	if c.ErrorHandler == nil {
+		c.ErrorHandler = errorHandler
+	}
+	return
+}
+
+func (p *Proxy[T, I]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.proxy.ServeHTTP(w, r)
+}
+
+// errorHandler replies 503 if there is no instance to select, or 502 otherwise
+func errorHandler(w http.ResponseWriter, _ *http.Request, err error) {
+	if errors.Is(err, loadbalance.ErrNoInstances) || errors.Is(err, loadbalance.ErrAllUnhealthy) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,219 @@
+package httpproxy_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ydmxcz/loadbalance"
+	"github.com/ydmxcz/loadbalance/httpproxy"
+)
+
+// backend is a instance of which the id is the URL of a httptest.Server
+type backend string
+
+func (b backend) InstanceID() string {
+	return string(b)
+}
+
+func (b backend) InstanceWeight() int {
+	return 1
+}
+
+func newBackend(t *testing.T, name string) (backend, *httptest.Server) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		io.WriteString(w, name)
+	}))
+	t.Cleanup(srv.Close)
+	return backend(srv.URL), srv
+}
+
+// deadBackend returns a instance of which the connection is refused
+func deadBackend(t *testing.T) backend {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	return backend(srv.URL)
+}
+
+func get(t *testing.T, client *http.Client, method, url string) (int, string) {
+	var body io.Reader
+	if method == http.MethodPost {
+		body = strings.NewReader("body")
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func TestProxy(t *testing.T) {
+	a, _ := newBackend(t, "a")
+	b, _ := newBackend(t, "b")
+	rr := loadbalance.NewRoundRobin[string, backend]()
+	rr.Add(a, b)
+	front := httptest.NewServer(httpproxy.New[string, backend](rr))
+	defer front.Close()
+
+	counts := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		code, body := get(t, front.Client(), http.MethodGet, front.URL)
+		if code != http.StatusOK {
+			t.Fatalf("got status %d", code)
+		}
+		counts[body]++
+	}
+	if counts["a"] != 5 || counts["b"] != 5 {
+		t.Fatalf("the requests were not round-robin: %v", counts)
+	}
+
+	rr.Del(a, b)
+	if code, _ := get(t, front.Client(), http.MethodGet, front.URL); code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d without instances, want 503", code)
+	}
+}
+
+func TestProxyRetry(t *testing.T) {
+	live, _ := newBackend(t, "live")
+	rr := loadbalance.NewRoundRobin[string, backend]()
+	rr.Add(deadBackend(t), live)
+	front := httptest.NewServer(httpproxy.New[string, backend](rr))
+	defer front.Close()
+
+	for i := 0; i < 10; i++ {
+		if code, body := get(t, front.Client(), http.MethodPut, front.URL); code != http.StatusOK || body != "live" {
+			t.Fatalf("a idempotent request got %d %q", code, body)
+		}
+	}
+	failed := 0
+	for i := 0; i < 10; i++ {
+		if code, _ := get(t, front.Client(), http.MethodPost, front.URL); code == http.StatusBadGateway {
+			failed++
+		}
+	}
+	if failed != 5 {
+		t.Fatalf("%d of the POST requests failed, want 5 that are not retried", failed)
+	}
+}
+
+// recorder is a load-balance that records what it is told
+type recorder struct {
+	*loadbalance.RoundRobin[string, backend]
+	mutex    sync.Mutex
+	done     int
+	observed int
+	errors   map[backend]int
+}
+
+func (r *recorder) Done(backend) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.done++
+}
+
+func (r *recorder) Observe(_ backend, rtt time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if rtt > 0 {
+		r.observed++
+	}
+}
+
+func (r *recorder) Report(ins backend, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err != nil {
+		r.errors[ins]++
+	}
+}
+
+func TestProxyReport(t *testing.T) {
+	live, _ := newBackend(t, "live")
+	dead := deadBackend(t)
+	rec := &recorder{RoundRobin: loadbalance.NewRoundRobin[string, backend](), errors: make(map[backend]int)}
+	rec.Add(dead, live)
+	front := httptest.NewServer(httpproxy.New[string, backend](rec))
+	defer front.Close()
+
+	for i := 0; i < 4; i++ {
+		get(t, front.Client(), http.MethodGet, front.URL)
+	}
+	get(t, front.Client(), http.MethodGet, front.URL+"/fail")
+
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	// every request ended on the live instance after the attempts on the dead one
+	if rec.errors[dead] == 0 || rec.done != rec.errors[dead]+5 {
+		t.Fatalf("done %d times with %d failed attempts, want every attempt done", rec.done, rec.errors[dead])
+	}
+	if rec.observed != 5 {
+		t.Fatalf("observed %d responses, want 5", rec.observed)
+	}
+	if rec.errors[live] != 1 {
+		t.Fatalf("reported %d errors of the live instance, want 1 of the status 500", rec.errors[live])
+	}
+}
+
+func TestProxyOutlierDetection(t *testing.T) {
+	live, _ := newBackend(t, "live")
+	dead := deadBackend(t)
+	od := loadbalance.NewOutlierDetector[string, backend](loadbalance.NewRoundRobin[string, backend](),
+		loadbalance.OutlierConfig{
+			ConsecutiveErrors:  1,
+			BaseEjectionTime:   time.Minute,
+			MaxEjectionPercent: 50,
+		})
+	od.Add(dead, live)
+	front := httptest.NewServer(httpproxy.New[string, backend](od))
+	defer front.Close()
+
+	for i := 0; i < 4; i++ {
+		if code, _ := get(t, front.Client(), http.MethodGet, front.URL); code != http.StatusOK {
+			t.Fatalf("got status %d", code)
+		}
+	}
+	if !od.Ejected(dead.InstanceID()) {
+		t.Fatal("the dead instance was not ejected")
+	}
+}
+
+// a load-aware selector behind a circuit breaker is told the end of every request
+func TestProxyCircuitBreakerLoadAware(t *testing.T) {
+	pc := loadbalance.NewP2C[string, backend]()
+	cb := loadbalance.NewCircuitBreaker[string, backend](pc)
+	a, _ := newBackend(t, "a")
+	b, _ := newBackend(t, "b")
+	c, _ := newBackend(t, "c")
+	cb.Add(a, b, c)
+	front := httptest.NewServer(httpproxy.New[string, backend](cb))
+	defer front.Close()
+
+	for i := 0; i < 30; i++ {
+		if code, _ := get(t, front.Client(), http.MethodGet, front.URL); code != http.StatusOK {
+			t.Fatalf("got status %d", code)
+		}
+	}
+	// the proxy closes the body of a response after the client has read it
+	deadline := time.Now().Add(time.Second)
+	for _, in := range []backend{a, b, c} {
+		for n := pc.Inflight(string(in)); n != 0; n = pc.Inflight(string(in)) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s has %d outstanding requests after the responses were read", in, n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
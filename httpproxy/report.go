@@ -0,0 +1,171 @@
+package httpproxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ydmxcz/loadbalance"
+)
+
+// instanceURL is the default way to find the URL of a instance: its id,
+// with the scheme "http" if it has none, e.g. "10.0.0.1:8080" or "https://10.0.0.1".
+func instanceURL[T loadbalance.Hashable, I loadbalance.Instance[T]](ins I) (*url.URL, error) {
+	id := fmt.Sprint(ins.InstanceID())
+	if !strings.Contains(id, "://") {
+		id = "http://" + id
+	}
+	return url.Parse(id)
+}
+
+// rewrite the request to be sent to the target, the path and query are kept
+func rewrite(req *http.Request, target *url.URL) {
+	req.URL.Scheme = target.Scheme
+	req.URL.Host = target.Host
+}
+
+// replayable returns whether the request can be sent again after the connection failed,
+// which are the idempotent requests with a body that can be read again, like net/http does.
+func replayable(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+	default:
+		_, ok := req.Header["Idempotency-Key"]
+		if _, xok := req.Header["X-Idempotency-Key"]; !ok && !xok {
+			return false
+		}
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// resend returns a copy of the request with a new body to be sent again
+func resend(req *http.Request) (*http.Request, error) {
+	r := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	return r, nil
+}
+
+// service is a load-balance and the way of selecting its instances for the requests,
+// by `selector`, or by `selectorBy` with the value of the header as the key.
+// `Proxy` and `Transport` both send the requests by `roundTrip` of it.
+type service[T loadbalance.Hashable, I loadbalance.Instance[T]] struct {
+	lb         any
+	config     *Config[T, I]
+	header     string
+	selector   loadbalance.Selector[T, I]
+	selectorBy loadbalance.SelectorBy[T, I]
+}
+
+// pick selects a instance for the request other than the excluded ones
+func (s *service[T, I]) pick(req *http.Request, exclude []T) (I, error) {
+	if s.selectorBy != nil {
+		return loadbalance.PickBy(req.Context(), s.selectorBy, req.Header.Get(s.header), exclude...)
+	}
+	return loadbalance.Pick(req.Context(), s.selector, exclude...)
+}
+
+// roundTrip sends a copy of the request rewritten to a instance by `Config.Transport`,
+// and sends it again to another instance while the connection fails and the request can be retried.
+// Like a `http.RoundTripper` it never modifies the request and closes its body on every error.
+func (s *service[T, I]) roundTrip(req *http.Request) (*http.Response, error) {
+	closeBody := func() {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+	}
+	ins, err := s.pick(req, nil)
+	if err != nil {
+		closeBody()
+		return nil, err
+	}
+	out := req.Clone(req.Context())
+	var tried []T
+	for {
+		target, terr := s.config.Target(ins)
+		if terr != nil {
+			release[T](s.lb, ins)
+			closeBody()
+			return nil, terr
+		}
+		rewrite(out, target)
+		start := time.Now()
+		resp, err := s.config.Transport.RoundTrip(out)
+		finish(s.lb, ins, start, resp, err)
+		if err == nil {
+			return resp, nil
+		}
+		tried = append(tried, ins.InstanceID())
+		if len(tried) > s.config.MaxRetries || req.Context().Err() != nil || !replayable(req) {
+			return nil, err
+		}
+		other, serr := s.pick(req, tried)
+		if serr != nil {
+			// there is no other instance, the error of the connection tells more
+			return nil, err
+		}
+		if out, serr = resend(req); serr != nil {
+			release[T](s.lb, other)
+			return nil, serr
+		}
+		ins = other
+	}
+}
+
+// finish tells the load-balance how the request sent to the instance went.
+// A response of status 5xx is reported as a failure, its latency is still observed.
+// The instance is done once the body of the response is closed, or at once if there is no response.
+func finish[T loadbalance.Hashable, I loadbalance.Instance[T]](lb any, ins I,
+	start time.Time, resp *http.Response, err error) {
+	if err == nil {
+		if o, ok := lb.(loadbalance.Observer[T, I]); ok {
+			o.Observe(ins, time.Since(start))
+		}
+	}
+	if r, ok := lb.(loadbalance.Reporter[T, I]); ok {
+		rerr := err
+		if err == nil && resp.StatusCode >= http.StatusInternalServerError {
+			rerr = fmt.Errorf("httpproxy: %s", resp.Status)
+		}
+		r.Report(ins, rerr)
+	}
+	d, ok := lb.(loadbalance.LoadAware[T, I])
+	if !ok {
+		return
+	}
+	if err != nil || resp.Body == nil {
+		d.Done(ins)
+		return
+	}
+	resp.Body = &doneBody{ReadCloser: resp.Body, done: func() { d.Done(ins) }}
+}
+
+// release the instance selected but not sent a request
+func release[T loadbalance.Hashable, I loadbalance.Instance[T]](lb any, ins I) {
+	if d, ok := lb.(loadbalance.LoadAware[T, I]); ok {
+		d.Done(ins)
+	}
+}
+
+// doneBody calls done the first time it is closed
+type doneBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *doneBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
@@ -0,0 +1,72 @@
+package httpproxy
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/ydmxcz/loadbalance"
+)
+
+// Transport is a `http.RoundTripper` that load-balances the requests for logical hosts,
+// e.g. a request for "http://orders/list" is sent to "http://10.0.0.1:8080/list"
+// if "orders" is registered with a load-balance selecting the instance "10.0.0.1:8080".
+// The requests for the hosts not registered are sent as they are.
+//
+// Like `Proxy` a idempotent request is retried on another instance when the connection fails,
+// and the load-balance is told how every request went.
+// `Config.ErrorHandler` and `Config.ErrorLog` are not used by a Transport.
+type Transport[T loadbalance.Hashable, I loadbalance.Instance[T]] struct {
+	mutex    sync.RWMutex
+	config   Config[T, I]
+	services map[string]*service[T, I]
+}
+
+// NewTransport returns a Transport without hosts registered,
+// the requests are sent by `Config.Transport` once rewritten.
+func NewTransport[T loadbalance.Hashable, I loadbalance.Instance[T]](config ...Config[T, I]) *Transport[T, I] {
+	return &Transport[T, I]{
+		config:   withDefaults(config),
+		services: make(map[string]*service[T, I]),
+	}
+}
+
+// Register the host with the selector, which replaces the one registered before
+func (t *Transport[T, I]) Register(host string, selector loadbalance.Selector[T, I]) {
+	t.register(host, &service[T, I]{lb: selector, config: &t.config, selector: selector})
+}
+
+// RegisterBy registers the host with the selector selecting by the value of the header,
+// e.g. a `ConsistentHash` keeps sending the requests of a user to the same instance by the header of the user id.
+// The requests without the header are selected by the empty key.
+func (t *Transport[T, I]) RegisterBy(host, header string, selector loadbalance.SelectorBy[T, I]) {
+	t.register(host, &service[T, I]{lb: selector, config: &t.config, header: header, selectorBy: selector})
+}
+
+func (t *Transport[T, I]) register(host string, s *service[T, I]) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.services[host] = s
+}
+
+// Unregister the host, of which the requests are sent as they are from now on
+func (t *Transport[T, I]) Unregister(host string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.services, host)
+}
+
+func (t *Transport[T, I]) service(host string) (*service[T, I], bool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	s, ok := t.services[host]
+	return s, ok
+}
+
+// RoundTrip sends the request to a instance of the load-balance registered with its host
+func (t *Transport[T, I]) RoundTrip(req *http.Request) (*http.Response, error) {
+	s, ok := t.service(req.URL.Host)
+	if !ok {
+		return t.config.Transport.RoundTrip(req)
+	}
+	return s.roundTrip(req)
+}
@@ -0,0 +1,155 @@
+package httpproxy_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ydmxcz/loadbalance"
+	"github.com/ydmxcz/loadbalance/httpproxy"
+)
+
+func newPathBackend(t *testing.T, name string) backend {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name+r.URL.Path)
+	}))
+	t.Cleanup(srv.Close)
+	return backend(srv.URL)
+}
+
+func TestTransport(t *testing.T) {
+	rr := loadbalance.NewRoundRobin[string, backend]()
+	rr.Add(newPathBackend(t, "a"), newPathBackend(t, "b"))
+	tr := httpproxy.NewTransport[string, backend]()
+	tr.Register("orders", rr)
+	client := &http.Client{Transport: tr}
+
+	counts := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		code, body := get(t, client, http.MethodGet, "http://orders/list")
+		if code != http.StatusOK {
+			t.Fatalf("got status %d", code)
+		}
+		counts[body]++
+	}
+	if counts["a/list"] != 5 || counts["b/list"] != 5 {
+		t.Fatalf("the requests were not round-robin: %v", counts)
+	}
+
+	// the hosts not registered are left alone
+	other := newPathBackend(t, "other")
+	if _, body := get(t, client, http.MethodGet, string(other)+"/x"); body != "other/x" {
+		t.Fatalf("got %q from a host not registered", body)
+	}
+
+	tr.Register("orders", loadbalance.NewRoundRobin[string, backend]())
+	if _, err := client.Get("http://orders/list"); !errors.Is(err, loadbalance.ErrNoInstances) {
+		t.Fatalf("got %v, want ErrNoInstances", err)
+	}
+	tr.Unregister("orders")
+	if _, err := client.Get("http://orders/list"); err == nil || errors.Is(err, loadbalance.ErrNoInstances) {
+		t.Fatalf("got %v after unregister, want the error of the host", err)
+	}
+}
+
+func TestTransportSticky(t *testing.T) {
+	ch := loadbalance.NewConsistentHash[string]()
+	for _, name := range []string{"a", "b", "c", "d"} {
+		ch.Add(newPathBackend(t, name))
+	}
+	tr := httpproxy.NewTransport[string, loadbalance.Instance[string]]()
+	tr.RegisterBy("orders", "X-User", ch)
+	client := &http.Client{Transport: tr}
+
+	do := func(user string) string {
+		req, _ := http.NewRequest(http.MethodGet, "http://orders/", nil)
+		req.Header.Set("X-User", user)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	seen := make(map[string]struct{})
+	for i := 0; i < 20; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		first := do(user)
+		for j := 0; j < 3; j++ {
+			if got := do(user); got != first {
+				t.Fatalf("%s was sent to %s and then %s", user, first, got)
+			}
+		}
+		seen[first] = struct{}{}
+	}
+	if len(seen) < 2 {
+		t.Fatalf("20 users were all sent to %v", seen)
+	}
+}
+
+func TestTransportRetry(t *testing.T) {
+	rr := loadbalance.NewRoundRobin[string, backend]()
+	rr.Add(deadBackend(t), newPathBackend(t, "live"))
+	tr := httpproxy.NewTransport[string, backend]()
+	tr.Register("orders", rr)
+	client := &http.Client{Transport: tr}
+
+	for i := 0; i < 10; i++ {
+		if code, body := get(t, client, http.MethodGet, "http://orders/"); code != http.StatusOK || body != "live/" {
+			t.Fatalf("a idempotent request got %d %q", code, body)
+		}
+	}
+}
+
+// a Transport tells the load-balance how the requests went the same as a Proxy
+func TestTransportReport(t *testing.T) {
+	live, _ := newBackend(t, "live")
+	dead := deadBackend(t)
+	rec := &recorder{RoundRobin: loadbalance.NewRoundRobin[string, backend](), errors: make(map[backend]int)}
+	rec.Add(dead, live)
+	tr := httpproxy.NewTransport[string, backend]()
+	tr.Register("orders", rec)
+	client := &http.Client{Transport: tr}
+
+	for i := 0; i < 4; i++ {
+		get(t, client, http.MethodGet, "http://orders/")
+	}
+	get(t, client, http.MethodGet, "http://orders/fail")
+
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	if rec.errors[dead] == 0 || rec.done != rec.errors[dead]+5 {
+		t.Fatalf("done %d times with %d failed attempts, want every attempt done", rec.done, rec.errors[dead])
+	}
+	if rec.observed != 5 || rec.errors[live] != 1 {
+		t.Fatalf("observed %d responses and %d errors of the live instance, want 5 and 1", rec.observed, rec.errors[live])
+	}
+}
+
+// a load-aware selector behind a outlier detector is told the end of every request
+func TestTransportOutlierDetectorLoadAware(t *testing.T) {
+	pc := loadbalance.NewP2C[string, backend]()
+	od := loadbalance.NewOutlierDetector[string, backend](pc)
+	a, _ := newBackend(t, "a")
+	b, _ := newBackend(t, "b")
+	od.Add(a, b)
+	tr := httpproxy.NewTransport[string, backend]()
+	tr.Register("orders", od)
+	client := &http.Client{Transport: tr}
+
+	for i := 0; i < 30; i++ {
+		if code, _ := get(t, client, http.MethodGet, "http://orders/"); code != http.StatusOK {
+			t.Fatalf("got status %d", code)
+		}
+	}
+	// the body of the response was closed by the client
+	for _, in := range []backend{a, b} {
+		if n := pc.Inflight(string(in)); n != 0 {
+			t.Fatalf("%s has %d outstanding requests after the responses were read", in, n)
+		}
+	}
+}
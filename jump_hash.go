@@ -0,0 +1,189 @@
+package loadbalance
+
+import (
+	"context"
+	"sync"
+
+	"github.com/alphadose/haxmap"
+)
+
+// jump is the jump consistent hash of Lamping & Veach,
+// it maps the key to a bucket in [0, buckets) and when the number of bucket grows
+// only about 1/buckets of the keys move, all of them to the new bucket.
+func jump(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// JumpHash is the jump consistent hash.
+// Instances are the buckets numbered by the order they were added,
+// it needs no memory per replica and spreads the keys evenly.
+//
+// Note that the order of bucket must be stable:
+// `Add` appends instances to the tail and `Del` can only remove the last bucket.
+// `Del` removes instances from the tail as long as the last bucket is one of the arguments,
+// any other instance is left in place and not counted,
+// delete the instances after it first.
+// Weights are not used.
+type JumpHash[T Hashable, I Instance[T]] struct {
+	rwmutex  sync.RWMutex
+	hashmap  *haxmap.Map[T, I]
+	buckets  []I
+	hashfunc strHashFunc
+}
+
+func NewJumpHash[T Hashable, I Instance[T]]() *JumpHash[T, I] {
+	return &JumpHash[T, I]{
+		hashmap:  haxmap.New[T, I](8),
+		buckets:  make([]I, 0, 8),
+		hashfunc: GetHashFunc[string](),
+	}
+}
+
+// Add appends some instances to the tail and return the number of successful operation
+func (jh *JumpHash[T, I]) Add(instances ...I) int {
+	jh.rwmutex.Lock()
+	defer jh.rwmutex.Unlock()
+	count := 0
+	for _, instance := range instances {
+		if _, ok := jh.hashmap.GetOrSet(instance.InstanceID(), instance); !ok {
+			jh.buckets = append(jh.buckets, instance)
+			count++
+		}
+	}
+	return count
+}
+
+// Del removes some instances from the tail and return the number of successful operation
+func (jh *JumpHash[T, I]) Del(instances ...I) int {
+	jh.rwmutex.Lock()
+	defer jh.rwmutex.Unlock()
+	del := make(map[T]struct{}, len(instances))
+	for _, instance := range instances {
+		del[instance.InstanceID()] = struct{}{}
+	}
+	count := 0
+	for len(jh.buckets) > 0 {
+		last := len(jh.buckets) - 1
+		id := jh.buckets[last].InstanceID()
+		if _, ok := del[id]; !ok {
+			break
+		}
+		jh.hashmap.Del(id)
+		var zero I
+		jh.buckets[last] = zero
+		jh.buckets = jh.buckets[:last]
+		count++
+	}
+	return count
+}
+
+// Replace the instances with the new set in one step
+// and return the number of instances added or deleted.
+// The instances kept stay in their order and the new ones are appended to the tail,
+// unlike `Del` any bucket can be removed, which moves the keys of the buckets after it.
+func (jh *JumpHash[T, I]) Replace(instances ...I) int {
+	jh.rwmutex.Lock()
+	defer jh.rwmutex.Unlock()
+	added, kept, set := splitReplace(instances, func(id T) bool {
+		_, ok := jh.hashmap.Get(id)
+		return ok
+	})
+	keptMap := make(map[T]I, len(kept))
+	for _, instance := range kept {
+		keptMap[instance.InstanceID()] = instance
+	}
+	buckets := make([]I, 0, len(set))
+	count := len(added)
+	for _, b := range jh.buckets {
+		id := b.InstanceID()
+		if instance, ok := keptMap[id]; ok {
+			buckets = append(buckets, instance)
+			jh.hashmap.Set(id, instance)
+		} else {
+			jh.hashmap.Del(id)
+			count++
+		}
+	}
+	for _, instance := range added {
+		jh.hashmap.Set(instance.InstanceID(), instance)
+	}
+	jh.buckets = append(buckets, added...)
+	return count
+}
+
+// Get the value corresponding to the key
+func (jh *JumpHash[T, I]) Get(key T) (I, bool) {
+	return haxMapGetVal(jh.hashmap, key)
+}
+
+// ForEach every instances. it is concurrency safe.
+func (jh *JumpHash[T, I]) ForEach(callback func(T, I) bool) {
+	haxMapForEach(jh.hashmap, callback)
+}
+
+func (jh *JumpHash[T, I]) Size() int {
+	return int(jh.hashmap.Len())
+}
+
+// Bucket returns the number of bucket the key goes to, or -1 if there is no bucket
+func (jh *JumpHash[T, I]) Bucket(key string) int {
+	jh.rwmutex.RLock()
+	defer jh.rwmutex.RUnlock()
+	if len(jh.buckets) == 0 {
+		return -1
+	}
+	return jump(jh.hashfunc(key), len(jh.buckets))
+}
+
+// SelectBy returns the instance of the bucket the key goes to
+func (jh *JumpHash[T, I]) SelectBy(key string) (ins I) {
+	h := jh.hashfunc(key)
+	jh.rwmutex.RLock()
+	defer jh.rwmutex.RUnlock()
+	if len(jh.buckets) == 0 {
+		return
+	}
+	return jh.buckets[jump(h, len(jh.buckets))]
+}
+
+// PickBy selects a instance for the key like `SelectBy`, or returns `ErrNoInstances` if there is none
+func (jh *JumpHash[T, I]) PickBy(ctx context.Context, key string) (I, error) {
+	return pick(ctx, func() (I, bool) { return jh.selectByExcluding(key, nil) }, nil)
+}
+
+// SelectByExcluding returns the instance of the bucket the key goes to,
+// or of the following buckets when it is excluded.
+func (jh *JumpHash[T, I]) SelectByExcluding(key string, exclude ...T) I {
+	ins, _ := jh.selectByExcluding(key, exclude)
+	return ins
+}
+
+func (jh *JumpHash[T, I]) selectByExcluding(key string, exclude []T) (ins I, ok bool) {
+	h := jh.hashfunc(key)
+	jh.rwmutex.RLock()
+	defer jh.rwmutex.RUnlock()
+	size := len(jh.buckets)
+	if size == 0 {
+		return
+	}
+	b := jump(h, size)
+	for i := 0; i < size; i++ {
+		ins = jh.buckets[(b+i)%size]
+		if !excluded(exclude, ins.InstanceID()) {
+			return ins, true
+		}
+	}
+	return *new(I), false
+}
+
+// SelectNBy returns at most n distinct instances for the key,
+// the instances of the bucket the key goes to and of the following buckets.
+func (jh *JumpHash[T, I]) SelectNBy(key string, n int) []I {
+	return selectNBy[T, I](jh, key, n)
+}
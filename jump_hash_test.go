@@ -0,0 +1,115 @@
+package loadbalance_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ydmxcz/loadbalance"
+)
+
+func TestJumpHashGrow(t *testing.T) {
+	var lb loadbalance.SelectorBy[string, *myService] = loadbalance.NewJumpHash[string, *myService]()
+	ins := getInstance(2)[:10]
+	lb.Add(ins[:9]...)
+
+	before := map[string]*myService{}
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		before[key] = lb.SelectBy(key)
+	}
+	lb.Add(ins[9])
+	moved := 0
+	for key, old := range before {
+		if sel := lb.SelectBy(key); sel != old {
+			if sel != ins[9] {
+				t.Fatalf("key %s moved to %s instead of the new bucket", key, sel.Address)
+			}
+			moved++
+		}
+	}
+	if moved < 800 || moved > 1200 {
+		t.Fatalf("%d keys moved, want about 1000", moved)
+	}
+}
+
+func TestJumpHashDelOnlyTail(t *testing.T) {
+	lb := loadbalance.NewJumpHash[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins...)
+
+	if n := lb.Del(ins[0]); n != 0 || lb.Size() != 3 {
+		t.Fatal("deleted a bucket which is not the last one")
+	}
+	// the order of arguments does not matter as long as they form the tail
+	if n := lb.Del(ins[1], ins[2]); n != 2 || lb.Size() != 1 {
+		t.Fatalf("deleted %d buckets, want 2", n)
+	}
+	if lb.Bucket("key") != 0 || lb.SelectBy("key") != ins[0] {
+		t.Fatal("key does not go to the only bucket")
+	}
+	lb.Del(ins[0])
+	if lb.Bucket("key") != -1 || lb.SelectBy("key") != nil {
+		t.Fatal("empty balancer selected a instance")
+	}
+}
+
+func TestJumpHashSelectByExcluding(t *testing.T) {
+	lb := loadbalance.NewJumpHash[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins...)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		first := lb.SelectBy(key)
+		for _, gone := range ins {
+			sel := lb.SelectByExcluding(key, gone.Address)
+			// only the keys of the excluded instance move, always to the same one
+			if first != gone && sel != first {
+				t.Fatalf("key %s moved from %s to %v", key, first.Address, sel)
+			}
+			if sel == nil || sel == gone || sel != lb.SelectByExcluding(key, gone.Address) {
+				t.Fatalf("key %s went to %v without %s", key, sel, gone.Address)
+			}
+		}
+	}
+	if sel := lb.SelectByExcluding("key", ins[0].Address, ins[1].Address, ins[2].Address); sel != nil {
+		t.Fatalf("got %s when all are excluded", sel.Address)
+	}
+}
+
+func TestJumpHashSelectNBy(t *testing.T) {
+	checkReplicas(t, loadbalance.NewJumpHash[string, *myService]())
+}
+
+func TestJumpHashPickBy(t *testing.T) {
+	lb := loadbalance.NewJumpHash[string, *myService]()
+	if _, err := lb.PickBy(context.Background(), "key"); !errors.Is(err, loadbalance.ErrNoInstances) {
+		t.Fatalf("got %v, want ErrNoInstances", err)
+	}
+	lb.Add(getInstance(1)...)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if sel, err := lb.PickBy(context.Background(), key); err != nil || sel != lb.SelectBy(key) {
+			t.Fatalf("picked %v, %v for key %s, want %s", sel, err, key, lb.SelectBy(key).Address)
+		}
+	}
+}
+
+func TestJumpHashReplace(t *testing.T) {
+	lb := loadbalance.NewJumpHash[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins[0], ins[1])
+	if n := lb.Replace(ins[2], ins[1]); n != 2 || lb.Size() != 2 {
+		t.Fatalf("replaced %d instances, %d left", n, lb.Size())
+	}
+	// the kept instance keeps its bucket and the new one is appended
+	want := loadbalance.NewJumpHash[string, *myService]()
+	want.Add(ins[1], ins[2])
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if sel := lb.SelectBy(key); sel != want.SelectBy(key) {
+			t.Fatalf("key %s went to %v after replace", key, sel)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package loadbalance
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+
+	"github.com/alphadose/haxmap"
+)
+
+// `connNode` is the element of `connHeap`,
+// `index` is its position in the heap or -1 once it was deleted.
+type connNode[T Hashable, I Instance[T]] struct {
+	instance I
+	weight   int64
+	active   int64
+	index    int
+}
+
+// connHeap is a indexed min-heap ordered by `active / weight`,
+// the instance with the larger weight wins when the ratios are equal.
+type connHeap[T Hashable, I Instance[T]] []*connNode[T, I]
+
+func (h connHeap[T, I]) Len() int {
+	return len(h)
+}
+
+func (h connHeap[T, I]) Less(i, j int) bool {
+	return lessConn(h[i], h[j])
+}
+
+func lessConn[T Hashable, I Instance[T]](a, b *connNode[T, I]) bool {
+	// a.active/a.weight < b.active/b.weight without division
+	l, r := a.active*b.weight, b.active*a.weight
+	if l != r {
+		return l < r
+	}
+	return a.weight > b.weight
+}
+
+func (h connHeap[T, I]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *connHeap[T, I]) Push(x any) {
+	n := x.(*connNode[T, I])
+	n.index = len(*h)
+	*h = append(*h, n)
+}
+
+func (h *connHeap[T, I]) Pop() any {
+	old := *h
+	n := old[len(old)-1]
+	old[len(old)-1] = nil
+	n.index = -1
+	*h = old[:len(old)-1]
+	return n
+}
+
+// instanceWeight returns the weight of instance, a non-positive weight is regarded as 1.
+func instanceWeight[T Hashable, I Instance[T]](instance I) int {
+	if w := instance.InstanceWeight(); w > 0 {
+		return w
+	}
+	return 1
+}
+
+// LeastConnections selects the instance with the lowest `active / InstanceWeight()`,
+// where `active` is the number of connections (or requests) currently open on it.
+// The instances are kept in a indexed heap, so select and done are O(log n)
+// and the implementation still scales to a lot of instances.
+//
+// Like `P2C`, every instance returned by `Select` must be passed to `Done`
+// once the connection to it was closed.
+// The weight of a instance is read when it is added.
+type LeastConnections[T Hashable, I Instance[T]] struct {
+	mutex   sync.Mutex
+	hashmap *haxmap.Map[T, *connNode[T, I]]
+	heap    connHeap[T, I]
+}
+
+func NewLeastConnections[T Hashable, I Instance[T]]() *LeastConnections[T, I] {
+	return &LeastConnections[T, I]{
+		hashmap: haxmap.New[T, *connNode[T, I]](8),
+		heap:    make(connHeap[T, I], 0, 8),
+	}
+}
+
+// Add some instances and return the number of successful operation
+func (lc *LeastConnections[T, I]) Add(instances ...I) int {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	count := 0
+	for _, instance := range instances {
+		id := instance.InstanceID()
+		if _, ok := lc.hashmap.Get(id); !ok {
+			n := &connNode[T, I]{
+				instance: instance,
+				weight:   int64(instanceWeight[T](instance)),
+			}
+			heap.Push(&lc.heap, n)
+			lc.hashmap.Set(id, n)
+			count++
+		}
+	}
+	return count
+}
+
+// Del some instances and return the number of successful operation
+func (lc *LeastConnections[T, I]) Del(instances ...I) int {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	count := 0
+	for _, instance := range instances {
+		id := instance.InstanceID()
+		if n, ok := lc.hashmap.Get(id); ok {
+			lc.hashmap.Del(id)
+			heap.Remove(&lc.heap, n.index)
+			count++
+		}
+	}
+	return count
+}
+
+// Replace the instances with the new set in one step
+// and return the number of instances added or deleted,
+// the instances kept keep their connections and are updated with their new value and weight.
+func (lc *LeastConnections[T, I]) Replace(instances ...I) int {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	added, kept, set := splitReplace(instances, func(id T) bool {
+		_, ok := lc.hashmap.Get(id)
+		return ok
+	})
+	var dels []*connNode[T, I]
+	for _, n := range lc.heap {
+		if _, ok := set[n.instance.InstanceID()]; !ok {
+			dels = append(dels, n)
+		}
+	}
+	for _, n := range dels {
+		lc.hashmap.Del(n.instance.InstanceID())
+		heap.Remove(&lc.heap, n.index)
+	}
+	for _, instance := range kept {
+		if n, ok := lc.hashmap.Get(instance.InstanceID()); ok {
+			n.instance = instance
+			n.weight = int64(instanceWeight[T](instance))
+			heap.Fix(&lc.heap, n.index)
+		}
+	}
+	for _, instance := range added {
+		n := &connNode[T, I]{
+			instance: instance,
+			weight:   int64(instanceWeight[T](instance)),
+		}
+		heap.Push(&lc.heap, n)
+		lc.hashmap.Set(instance.InstanceID(), n)
+	}
+	return len(added) + len(dels)
+}
+
+// Get the value corresponding to the key
+func (lc *LeastConnections[T, I]) Get(key T) (ins I, ok bool) {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	if n, ok := haxMapGetVal(lc.hashmap, key); ok {
+		return n.instance, true
+	}
+	return
+}
+
+// ForEach every instances. it is concurrency safe,
+// the callback is called on a copy of the instances taken under the lock.
+func (lc *LeastConnections[T, I]) ForEach(callback func(T, I) bool) {
+	lc.mutex.Lock()
+	instances := make([]I, len(lc.heap))
+	for i, n := range lc.heap {
+		instances[i] = n.instance
+	}
+	lc.mutex.Unlock()
+	for _, instance := range instances {
+		if !callback(instance.InstanceID(), instance) {
+			return
+		}
+	}
+}
+
+func (lc *LeastConnections[T, I]) Size() int {
+	return int(lc.hashmap.Len())
+}
+
+// Active returns the number of connections currently open on the instance.
+func (lc *LeastConnections[T, I]) Active(key T) int64 {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	if n, ok := lc.hashmap.Get(key); ok {
+		return n.active
+	}
+	return 0
+}
+
+// Select the least loaded instance and count a new connection on it
+func (lc *LeastConnections[T, I]) Select() (ins I) {
+	ins, _ = lc.selectNode(nil)
+	return
+}
+
+// Pick selects a instance like `Select`, or returns `ErrNoInstances` if there is none
+func (lc *LeastConnections[T, I]) Pick(ctx context.Context) (I, error) {
+	return pick(ctx, func() (I, bool) { return lc.selectExcluding(nil) }, nil)
+}
+
+// SelectN selects the n least loaded instances,
+// every one of them must be passed to `Done`
+func (lc *LeastConnections[T, I]) SelectN(n int) []I {
+	return selectN[T, I](lc, n)
+}
+
+// SelectExcluding selects the least loaded instance other than the excluded ones
+func (lc *LeastConnections[T, I]) SelectExcluding(exclude ...T) I {
+	ins, _ := lc.selectExcluding(exclude)
+	return ins
+}
+
+func (lc *LeastConnections[T, I]) selectExcluding(exclude []T) (ins I, ok bool) {
+	ins, n := lc.selectNode(exclude)
+	return ins, n != nil
+}
+
+// SelectWithDone selects a instance like `Select` and returns a callback
+// that closes the connection on it. The callback is safe to be called more than once.
+func (lc *LeastConnections[T, I]) SelectWithDone() (ins I, done func()) {
+	ins, n := lc.selectNode(nil)
+	if n == nil {
+		return ins, func() {}
+	}
+	return ins, onceDone(func() { lc.release(n) })
+}
+
+// Done reports that a connection to the instance was closed
+func (lc *LeastConnections[T, I]) Done(ins I) {
+	if n, ok := lc.hashmap.Get(ins.InstanceID()); ok {
+		lc.release(n)
+	}
+}
+
+// selectNode takes the top of heap,
+// or scans the heap for the least loaded one when some instances are excluded.
+// It returns the instance of the node as well, it is read under the lock since `Replace` updates the kept instances.
+func (lc *LeastConnections[T, I]) selectNode(exclude []T) (ins I, n *connNode[T, I]) {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	if len(lc.heap) == 0 {
+		return
+	}
+	n = lc.heap[0]
+	if len(exclude) != 0 {
+		n = nil
+		for _, c := range lc.heap {
+			if !excluded(exclude, c.instance.InstanceID()) && (n == nil || lessConn(c, n)) {
+				n = c
+			}
+		}
+		if n == nil {
+			return
+		}
+	}
+	n.active++
+	heap.Fix(&lc.heap, n.index)
+	return n.instance, n
+}
+
+func (lc *LeastConnections[T, I]) release(n *connNode[T, I]) {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	// the node was deleted, nothing to fix
+	if n.index < 0 {
+		return
+	}
+	if n.active > 0 {
+		n.active--
+		heap.Fix(&lc.heap, n.index)
+	}
+}
@@ -0,0 +1,144 @@
+package loadbalance_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ydmxcz/loadbalance"
+)
+
+func TestLeastConnectionsWeighted(t *testing.T) {
+	lb := loadbalance.NewLeastConnections[string, *myService]()
+	// weight 5, 3, 2
+	ins := getInstance(1)
+	lb.Add(ins...)
+
+	// without `Done` the connections are opened in proportion to the weights
+	m := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		m[lb.Select().InstanceID()]++
+	}
+	for _, in := range ins {
+		if want := in.Memory * 100; m[in.Address] != want {
+			t.Fatalf("%s got %d connections, want %d", in.Address, m[in.Address], want)
+		}
+	}
+
+	// the heaviest instance wins the tie
+	if got := lb.Select(); got != ins[0] {
+		t.Fatalf("tie broken towards %s", got.Address)
+	}
+	lb.Done(ins[0])
+
+	// closing connections makes the instance preferred again
+	for i := 0; i < 10; i++ {
+		lb.Done(ins[2])
+	}
+	if got := lb.Select(); got != ins[2] {
+		t.Fatalf("selected %s, want %s", got.Address, ins[2].Address)
+	}
+}
+
+func TestLeastConnectionsDel(t *testing.T) {
+	lb := loadbalance.NewLeastConnections[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins...)
+	sel, done := lb.SelectWithDone()
+	if lb.Del(sel) != 1 || lb.Size() != 2 {
+		t.Fatal("delete failed")
+	}
+	// finishing a connection of a deleted instance is harmless
+	done()
+	for i := 0; i < 100; i++ {
+		if lb.Select() == sel {
+			t.Fatal("selected a deleted instance")
+		}
+	}
+}
+
+func TestLeastConnectionsSelectExcluding(t *testing.T) {
+	lb := loadbalance.NewLeastConnections[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins...)
+
+	// ins[0] is the least loaded but excluded, ins[1] is busy
+	if sel := lb.SelectExcluding(ins[0].Address, ins[2].Address); sel != ins[1] {
+		t.Fatalf("selected %v, want %s", sel, ins[1].Address)
+	}
+	for i := 0; i < 100; i++ {
+		sel := lb.SelectExcluding(ins[0].Address)
+		if sel != ins[2] {
+			t.Fatalf("selected %v, want the least loaded %s", sel, ins[2].Address)
+		}
+		lb.Done(sel)
+	}
+	if sel := lb.SelectExcluding(ins[0].Address, ins[1].Address, ins[2].Address); sel != nil {
+		t.Fatalf("got %s when all are excluded", sel.Address)
+	}
+}
+
+func TestLeastConnectionsSelectN(t *testing.T) {
+	lb := loadbalance.NewLeastConnections[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins...)
+
+	// keep ins[0] busy, the two least loaded are the others
+	busy := lb.SelectExcluding(ins[1].Address, ins[2].Address)
+	for i := 0; i < 100; i++ {
+		res := lb.SelectN(2)
+		checkDistinct(t, res, 2)
+		for _, in := range res {
+			if in == busy {
+				t.Fatalf("selected the busy %s", busy.Address)
+			}
+			lb.Done(in)
+		}
+	}
+}
+
+func TestLeastConnectionsPick(t *testing.T) {
+	lb := loadbalance.NewLeastConnections[string, *myService]()
+	if _, err := lb.Pick(context.Background()); !errors.Is(err, loadbalance.ErrNoInstances) {
+		t.Fatalf("got %v, want ErrNoInstances", err)
+	}
+	ins := getInstance(1)
+	lb.Add(ins[1], ins[2])
+
+	// the picked instance is loaded, so the next pick is the other one
+	first, err := lb.Pick(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second, err := lb.Pick(context.Background()); err != nil || second == first {
+		t.Fatalf("picked %v, %v after %s", second, err, first.Address)
+	}
+}
+
+func TestLeastConnectionsReplace(t *testing.T) {
+	lb := loadbalance.NewLeastConnections[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins[0], ins[1])
+	lb.SelectN(2)
+	// the kept instance gets the weight 30 and keeps its connection
+	updated := &myService{Address: ins[1].Address, Memory: 30}
+	if n := lb.Replace(updated, ins[2]); n != 2 || lb.Size() != 2 {
+		t.Fatalf("replaced %d instances, %d left", n, lb.Size())
+	}
+	if got, ok := lb.Get(ins[1].Address); !ok || got != updated {
+		t.Fatalf("got %v, want the new value of the kept instance", got)
+	}
+	if n := lb.Active(ins[1].Address); n != 1 {
+		t.Fatalf("%d connections of the kept instance, want 1", n)
+	}
+	// 1/30 is less than 0/2 only after the new instance has a connection
+	if sel := lb.Select(); sel != ins[2] {
+		t.Fatalf("selected %v, want the new instance", sel)
+	}
+	for i := 0; i < 10; i++ {
+		if sel := lb.Select(); sel != updated {
+			t.Fatalf("selected %v, want the kept instance with the weight 30", sel)
+		}
+	}
+	checkReplaceAtomic(t, loadbalance.NewLeastConnections[string, *myService]())
+}
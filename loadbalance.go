@@ -1,5 +1,22 @@
 package loadbalance
 
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/alphadose/haxmap"
+)
+
+var (
+	// ErrNoInstances is returned by `Pick` when the load-balance has no instance
+	ErrNoInstances = errors.New("loadbalance: no instances")
+	// ErrAllUnhealthy is returned by `Pick` when the load-balance has instances
+	// but all of them are unhealthy, ejected or have a open breaker
+	ErrAllUnhealthy = errors.New("loadbalance: all instances are unhealthy")
+)
+
 type Instance[T Hashable] interface {
 	InstanceID() T
 	InstanceWeight() int
@@ -22,3 +39,302 @@ type Selector[T Hashable, I Instance[T]] interface {
 	base[T, I]
 	Select() I
 }
+
+// LoadAware is a Selector that counts the outstanding requests of every instance.
+// Every instance returned by `Select` must be passed to `Done`
+// once the request sent to it has finished.
+type LoadAware[T Hashable, I Instance[T]] interface {
+	Selector[T, I]
+	Done(I)
+}
+
+// Observer is a load-balance that estimates the latency of every instance, e.g. `PeakEWMA`,
+// the latency of every request sent to a instance is passed to `Observe`.
+type Observer[T Hashable, I Instance[T]] interface {
+	base[T, I]
+	Observe(I, time.Duration)
+}
+
+// Reporter is a load-balance that ejects the instances failing the requests,
+// e.g. `OutlierDetector` and `CircuitBreaker`,
+// the result of every request sent to a instance is passed to `Report`, nil for a success.
+type Reporter[T Hashable, I Instance[T]] interface {
+	base[T, I]
+	Report(I, error)
+}
+
+// Balancer is the set of instances every load-balance manages,
+// `Selector` and `SelectorBy` only differ in the way of selecting.
+type Balancer[T Hashable, I Instance[T]] interface {
+	base[T, I]
+}
+
+// ExcludingSelector is a Selector that can select a instance other than the given ones,
+// a retry uses it so that the request never goes back to a instance that already failed it.
+type ExcludingSelector[T Hashable, I Instance[T]] interface {
+	Selector[T, I]
+	SelectExcluding(exclude ...T) I
+}
+
+// ExcludingSelectorBy is a SelectorBy that can select a instance other than the given ones,
+// hash based load-balances walk to the next distinct instance of the key.
+type ExcludingSelectorBy[T Hashable, I Instance[T]] interface {
+	SelectorBy[T, I]
+	SelectByExcluding(key string, exclude ...T) I
+}
+
+// excluded returns whether the id is one of the excluded ids,
+// there are only a few of them so a linear search is the fastest.
+func excluded[T Hashable](exclude []T, id T) bool {
+	for _, e := range exclude {
+		if e == id {
+			return true
+		}
+	}
+	return false
+}
+
+// isZero returns whether the instance is the zero value of I,
+// that is what a load-balance returns when it has no instance to select.
+func isZero[T Hashable, I Instance[T]](ins I) bool {
+	return reflect.ValueOf(&ins).Elem().IsZero()
+}
+
+// selectExcluding selects a instance of any Selector other than the excluded ones.
+// The load-balances of the package select it directly,
+// any other Selector is asked at most `Size()` times.
+func selectExcluding[T Hashable, I Instance[T]](s Selector[T, I], exclude []T) (ins I, ok bool) {
+	switch es := s.(type) {
+	case interface{ selectExcluding([]T) (I, bool) }:
+		return es.selectExcluding(exclude)
+	case ExcludingSelector[T, I]:
+		ins = es.SelectExcluding(exclude...)
+		return ins, !isZero[T](ins)
+	}
+	for i := s.Size(); i > 0; i-- {
+		ins = s.Select()
+		if isZero[T](ins) {
+			return
+		}
+		if !excluded(exclude, ins.InstanceID()) {
+			return ins, true
+		}
+	}
+	return *new(I), false
+}
+
+// SelectorN is a Selector that can select n distinct instances in one call,
+// e.g. for quorum writes, hedged requests and replica placement.
+type SelectorN[T Hashable, I Instance[T]] interface {
+	Selector[T, I]
+	SelectN(n int) []I
+}
+
+// SelectorNBy is a SelectorBy that can select n distinct instances for a key in one call,
+// the first one is the instance `SelectBy` returns.
+type SelectorNBy[T Hashable, I Instance[T]] interface {
+	SelectorBy[T, I]
+	SelectNBy(key string, n int) []I
+}
+
+// selectN selects at most n distinct instances by selecting again without the selected ones,
+// so a weighted load-balance samples by weight without replacement.
+func selectN[T Hashable, I Instance[T]](s Selector[T, I], n int) []I {
+	if n <= 0 {
+		return nil
+	}
+	if size := s.Size(); n > size {
+		n = size
+	}
+	res := make([]I, 0, n)
+	selected := make([]T, 0, n)
+	for len(res) < n {
+		ins, ok := selectExcluding(s, selected)
+		if !ok {
+			break
+		}
+		res = append(res, ins)
+		selected = append(selected, ins.InstanceID())
+	}
+	return res
+}
+
+// selectNBy is the same as `selectN` for the hash based load-balances,
+// every instance is the next distinct one of the key.
+func selectNBy[T Hashable, I Instance[T]](s interface {
+	Size() int
+	selectByExcluding(string, []T) (I, bool)
+}, key string, n int) []I {
+	if n <= 0 {
+		return nil
+	}
+	if size := s.Size(); n > size {
+		n = size
+	}
+	res := make([]I, 0, n)
+	selected := make([]T, 0, n)
+	for len(res) < n {
+		ins, ok := s.selectByExcluding(key, selected)
+		if !ok {
+			break
+		}
+		res = append(res, ins)
+		selected = append(selected, ins.InstanceID())
+	}
+	return res
+}
+
+// Picker is a Selector that returns a error instead of the zero value of I
+// when there is no instance to select.
+type Picker[T Hashable, I Instance[T]] interface {
+	Selector[T, I]
+	Pick(ctx context.Context) (I, error)
+}
+
+// PickerBy is a SelectorBy that returns a error instead of the zero value of I
+// when there is no instance to select.
+type PickerBy[T Hashable, I Instance[T]] interface {
+	SelectorBy[T, I]
+	PickBy(ctx context.Context, key string) (I, error)
+}
+
+// selectByExcluding is the same as `selectExcluding` for any SelectorBy
+func selectByExcluding[T Hashable, I Instance[T]](s SelectorBy[T, I], key string, exclude []T) (ins I, ok bool) {
+	switch es := s.(type) {
+	case interface{ selectByExcluding(string, []T) (I, bool) }:
+		return es.selectByExcluding(key, exclude)
+	case ExcludingSelectorBy[T, I]:
+		ins = es.SelectByExcluding(key, exclude...)
+		return ins, !isZero[T](ins)
+	}
+	ins = s.SelectBy(key)
+	if isZero[T](ins) || excluded(exclude, ins.InstanceID()) {
+		return *new(I), false
+	}
+	return ins, true
+}
+
+// pick selects a instance by `sel` and turns a failed selection into a error,
+// `registered` returns the number of instances including the unhealthy ones,
+// it is nil for the load-balances that keep no unhealthy instance.
+func pick[I any](ctx context.Context, sel func() (I, bool), registered func() int) (ins I, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if ins, ok := sel(); ok {
+		return ins, nil
+	}
+	if registered != nil && registered() > 0 {
+		return ins, ErrAllUnhealthy
+	}
+	return ins, ErrNoInstances
+}
+
+// Pick selects a instance of any Selector other than the excluded ones, e.g. for a retry,
+// by `Pick` of the selector if it is a Picker and nothing is excluded.
+// It returns `ErrNoInstances` instead of the zero value of I if there is no instance to select.
+func Pick[T Hashable, I Instance[T]](ctx context.Context, s Selector[T, I], exclude ...T) (I, error) {
+	if p, ok := s.(Picker[T, I]); ok && len(exclude) == 0 {
+		return p.Pick(ctx)
+	}
+	return pick(ctx, func() (I, bool) { return selectExcluding(s, exclude) }, nil)
+}
+
+// PickBy is the same as `Pick` for any SelectorBy
+func PickBy[T Hashable, I Instance[T]](ctx context.Context, s SelectorBy[T, I], key string, exclude ...T) (I, error) {
+	if p, ok := s.(PickerBy[T, I]); ok && len(exclude) == 0 {
+		return p.PickBy(ctx, key)
+	}
+	return pick(ctx, func() (I, bool) { return selectByExcluding(s, key, exclude) }, nil)
+}
+
+// Replacer is a load-balance that replaces its whole set of instances in one step,
+// e.g. with the complete list of endpoints service discovery hands over on every update.
+// `Select` sees either the old set or the new one, never a mix of them.
+type Replacer[T Hashable, I Instance[T]] interface {
+	base[T, I]
+	Replace(instances ...I) int
+}
+
+// splitReplace splits the new set of instances into the ones to add and the ones to keep,
+// a instance repeated in the set is only counted once.
+func splitReplace[T Hashable, I Instance[T]](instances []I, has func(T) bool) (added, kept []I, set map[T]struct{}) {
+	set = make(map[T]struct{}, len(instances))
+	for _, instance := range instances {
+		id := instance.InstanceID()
+		if _, ok := set[id]; ok {
+			continue
+		}
+		set[id] = struct{}{}
+		if has(id) {
+			kept = append(kept, instance)
+		} else {
+			added = append(added, instance)
+		}
+	}
+	return
+}
+
+// replaceNodes replaces the nodes of a load-balance keeping them in a slice and a haxmap,
+// `newNode` creates the node of a added instance and `update` the node of a kept one.
+// It returns the nodes in the order of the new set and the number of instances added or deleted,
+// the caller must hold the lock of the slice.
+func replaceNodes[T Hashable, I Instance[T], N any](m *haxmap.Map[T, N], old []N, instances []I,
+	id func(N) T, newNode func(I) N, update func(N, I) N) ([]N, int) {
+	nodes := make([]N, 0, len(instances))
+	set := make(map[T]struct{}, len(instances))
+	count := 0
+	for _, instance := range instances {
+		key := instance.InstanceID()
+		if _, ok := set[key]; ok {
+			continue
+		}
+		set[key] = struct{}{}
+		if n, ok := m.Get(key); ok {
+			nodes = append(nodes, update(n, instance))
+		} else {
+			nodes = append(nodes, newNode(instance))
+			count++
+		}
+	}
+	for _, n := range old {
+		if _, ok := set[id(n)]; !ok {
+			m.Del(id(n))
+			count++
+		}
+	}
+	for _, n := range nodes {
+		m.Set(id(n), n)
+	}
+	return nodes, count
+}
+
+// Replace the instances of any load-balance with the new set, e.g. on every update of service discovery,
+// and return the number of instances added or deleted.
+// It is only one step if the load-balance is a Replacer,
+// otherwise the instances not in the new set are deleted before the new ones are added.
+func Replace[T Hashable, I Instance[T]](b Balancer[T, I], instances ...I) int {
+	if r, ok := b.(Replacer[T, I]); ok {
+		return r.Replace(instances...)
+	}
+	set := make(map[T]struct{}, len(instances))
+	for _, instance := range instances {
+		set[instance.InstanceID()] = struct{}{}
+	}
+	var dels []I
+	b.ForEach(func(id T, ins I) bool {
+		if _, ok := set[id]; !ok {
+			dels = append(dels, ins)
+		}
+		return true
+	})
+	return b.Del(dels...) + b.Add(instances...)
+}
+
+// replaceInstances is `replaceNodes` for a load-balance keeping the instances themselves in the slice
+func replaceInstances[T Hashable, I Instance[T]](m *haxmap.Map[T, I], old []I, instances []I) ([]I, int) {
+	return replaceNodes(m, old, instances,
+		func(ins I) T { return ins.InstanceID() },
+		func(ins I) I { return ins },
+		func(_ I, ins I) I { return ins })
+}
@@ -1,7 +1,10 @@
 package loadbalance_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"sync"
 	"testing"
 	"unicode/utf8"
@@ -62,11 +65,86 @@ func getInstance(mode int) []*myService {
 	return instances
 }
 
+// checkDistinct checks that the instances selected are want distinct ones
+func checkDistinct[I loadbalance.Instance[string]](t *testing.T, res []I, want int) {
+	t.Helper()
+	if len(res) != want {
+		t.Fatalf("selected %d instances, want %d", len(res), want)
+	}
+	seen := make(map[string]bool)
+	for _, ins := range res {
+		if seen[ins.InstanceID()] {
+			t.Fatalf("selected %s twice", ins.InstanceID())
+		}
+		seen[ins.InstanceID()] = true
+	}
+}
+
+// checkLeftOut checks that SelectN(2) of the weights 5,3,2 of `getInstance(1)` samples without replacement,
+// P(5 left out) = 0.3*2/7 + 0.2*3/8, P(3 left out) = 0.5*2/5 + 0.2*5/8, P(2 left out) = 0.5*3/5 + 0.3*5/7.
+func checkLeftOut(t *testing.T, s loadbalance.SelectorN[string, *myService]) {
+	t.Helper()
+	want := []float64{
+		0.3*2/7 + 0.2*3/8,
+		0.5*2/5 + 0.2*5/8,
+		0.5*3/5 + 0.3*5/7,
+	}
+	ins := getInstance(1)
+	s.Add(ins...)
+	const rounds = 100000
+	left := make(map[string]int)
+	for i := 0; i < rounds; i++ {
+		res := s.SelectN(2)
+		checkDistinct(t, res, 2)
+		for _, in := range ins {
+			if in != res[0] && in != res[1] {
+				left[in.Address]++
+			}
+		}
+	}
+	for i, in := range ins {
+		got := float64(left[in.Address]) / rounds
+		if math.Abs(got-want[i]) > 0.01 {
+			t.Fatalf("%s was left out with %.3f, want %.3f", in.Address, got, want[i])
+		}
+	}
+}
+
+// checkReplicas checks that the replicas of the keys are where the retries excluding the ones before go
+func checkReplicas(t *testing.T, s interface {
+	loadbalance.SelectorNBy[string, *myService]
+	loadbalance.ExcludingSelectorBy[string, *myService]
+}) {
+	t.Helper()
+	checkDistinct(t, s.SelectNBy("key", 2), 0)
+	ins := getInstance(1)
+	s.Add(ins...)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		res := s.SelectNBy(key, 5)
+		checkDistinct(t, res, len(ins))
+		for j, in := range res {
+			exclude := make([]string, 0, j)
+			for _, r := range res[:j] {
+				exclude = append(exclude, r.Address)
+			}
+			if sel := s.SelectByExcluding(key, exclude...); sel != in {
+				t.Fatalf("replica %d of key %s is %s, want %v", j, key, in.Address, sel)
+			}
+		}
+	}
+}
+
 func TestSupplirLoadBalance(t *testing.T) {
 	testSupplirLoadBalance("Random LoadBalance", loadbalance.NewRandom[string, *myService](), t)
 	testSupplirLoadBalance("RoundRobin LoadBalance", loadbalance.NewRoundRobin[string, *myService](), t)
 	testSupplirLoadBalance("DynamicWeighted LoadBalance", loadbalance.NewDynamicWeighted[string, *myService](), t)
 	testSupplirLoadBalance("WeightedRandom LoadBalance", loadbalance.NewWeightedRandom[string, *myService](), t)
+	testSupplirLoadBalance("P2C LoadBalance", loadbalance.NewP2C[string, *myService](), t)
+	testSupplirLoadBalance("LeastConnections LoadBalance", loadbalance.NewLeastConnections[string, *myService](), t)
+	testSupplirLoadBalance("PeakEWMA LoadBalance", loadbalance.NewPeakEWMA[string, *myService](), t)
+	testSupplirLoadBalance("SmoothWeightedRoundRobin LoadBalance", loadbalance.NewSmoothWeightedRoundRobin[string, *myService](), t)
+	testSupplirLoadBalance("AliasRandom LoadBalance", loadbalance.NewAliasRandom[string, *myService](), t)
 }
 
 func testSupplirLoadBalance(name string, lb loadbalance.Selector[string, *myService], t *testing.T) {
@@ -106,6 +184,12 @@ func Benchmark_DynamicWeighted_Parallel(b *testing.B) {
 	b.Run("DynamicWeighted 16384 Instances", func(b *testing.B) {
 		benchmarkLoadBalanceParallel(loadbalance.NewDynamicWeighted[string, *myService](), getInstance(2), b)
 	})
+	b.Run("ShardedDynamicWeighted 3 Instances", func(b *testing.B) {
+		benchmarkLoadBalanceParallel(loadbalance.NewShardedDynamicWeighted[string, *myService](), getInstance(1), b)
+	})
+	b.Run("ShardedDynamicWeighted 16384 Instances", func(b *testing.B) {
+		benchmarkLoadBalanceParallel(loadbalance.NewShardedDynamicWeighted[string, *myService](), getInstance(2), b)
+	})
 }
 
 func Benchmark_GetterLoadBalance_Get_Parallel(b *testing.B) {
@@ -127,6 +211,36 @@ func Benchmark_GetterLoadBalance_Get_Parallel(b *testing.B) {
 	b.Run("WeightedRandom LoadBalance-16384", func(b *testing.B) {
 		benchmarkLoadBalanceParallel(loadbalance.NewWeightedRandom[string, *myService](), getInstance(2), b)
 	})
+	b.Run("P2C LoadBalance-3", func(b *testing.B) {
+		benchmarkLoadBalanceParallel(loadbalance.NewP2C[string, *myService](), getInstance(1), b)
+	})
+	b.Run("P2C LoadBalance-16384", func(b *testing.B) {
+		benchmarkLoadBalanceParallel(loadbalance.NewP2C[string, *myService](), getInstance(2), b)
+	})
+	b.Run("LeastConnections LoadBalance-3", func(b *testing.B) {
+		benchmarkLoadBalanceParallel(loadbalance.NewLeastConnections[string, *myService](), getInstance(1), b)
+	})
+	b.Run("LeastConnections LoadBalance-16384", func(b *testing.B) {
+		benchmarkLoadBalanceParallel(loadbalance.NewLeastConnections[string, *myService](), getInstance(2), b)
+	})
+	b.Run("PeakEWMA LoadBalance-3", func(b *testing.B) {
+		benchmarkLoadBalanceParallel(loadbalance.NewPeakEWMA[string, *myService](), getInstance(1), b)
+	})
+	b.Run("PeakEWMA LoadBalance-16384", func(b *testing.B) {
+		benchmarkLoadBalanceParallel(loadbalance.NewPeakEWMA[string, *myService](), getInstance(2), b)
+	})
+	b.Run("SmoothWeightedRoundRobin LoadBalance-3", func(b *testing.B) {
+		benchmarkLoadBalanceParallel(loadbalance.NewSmoothWeightedRoundRobin[string, *myService](), getInstance(1), b)
+	})
+	b.Run("SmoothWeightedRoundRobin LoadBalance-16384", func(b *testing.B) {
+		benchmarkLoadBalanceParallel(loadbalance.NewSmoothWeightedRoundRobin[string, *myService](), getInstance(2), b)
+	})
+	b.Run("AliasRandom LoadBalance-3", func(b *testing.B) {
+		benchmarkLoadBalanceParallel(loadbalance.NewAliasRandom[string, *myService](), getInstance(1), b)
+	})
+	b.Run("AliasRandom LoadBalance-16384", func(b *testing.B) {
+		benchmarkLoadBalanceParallel(loadbalance.NewAliasRandom[string, *myService](), getInstance(2), b)
+	})
 }
 
 func clearSymbol(text []byte, check func(rune) bool) []byte {
@@ -237,3 +351,109 @@ func BenchmarkHaxMapGet_Parallel(b *testing.B) {
 		}
 	})
 }
+
+// checkReplaceAtomic checks that replacing a set with a disjoint one never leaves `Select` without a instance,
+// which is what deleting and then adding does.
+func checkReplaceAtomic(t *testing.T, s interface {
+	loadbalance.Replacer[string, *myService]
+	Select() *myService
+}) {
+	t.Helper()
+	setA := getInstance(1)
+	setB := []*myService{
+		{Address: "192.168.1.1:1", Memory: 1},
+		{Address: "192.168.1.2:1", Memory: 4},
+	}
+	s.Add(setA...)
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(stop)
+		for i := 0; i < 200; i++ {
+			if i%2 == 0 {
+				s.Replace(setB...)
+			} else {
+				s.Replace(setA...)
+			}
+		}
+	}()
+	defer wg.Wait()
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if s.Select() == nil {
+			t.Fatal("selected nothing while replacing")
+		}
+	}
+}
+
+// plainSelector hides every method of the selector but the ones of `Selector`
+type plainSelector struct {
+	loadbalance.Selector[string, *myService]
+}
+
+func TestPick(t *testing.T) {
+	ins := getInstance(1)
+	rr := loadbalance.NewRoundRobin[string, *myService]()
+	for _, s := range []loadbalance.Selector[string, *myService]{rr, plainSelector{rr}} {
+		if _, err := loadbalance.Pick(context.Background(), s); !errors.Is(err, loadbalance.ErrNoInstances) {
+			t.Fatalf("got %v from a empty selector, want ErrNoInstances", err)
+		}
+		s.Add(ins...)
+		for i := 0; i < 100; i++ {
+			if sel, err := loadbalance.Pick(context.Background(), s, ins[0].Address, ins[1].Address); err != nil || sel != ins[2] {
+				t.Fatalf("picked %v, %v when the others are excluded", sel, err)
+			}
+		}
+		if _, err := loadbalance.Pick(context.Background(), s, ins[0].Address, ins[1].Address, ins[2].Address); !errors.Is(err, loadbalance.ErrNoInstances) {
+			t.Fatalf("got %v when all are excluded, want ErrNoInstances", err)
+		}
+		s.Del(ins...)
+	}
+
+	// the error of the Picker is returned when nothing is excluded
+	cb := loadbalance.NewCircuitBreaker[string, *myService](loadbalance.NewRoundRobin[string, *myService](),
+		loadbalance.BreakerConfig{FailureThreshold: 1})
+	cb.Add(ins[0])
+	cb.Report(ins[0], errors.New("failed"))
+	if _, err := loadbalance.Pick[string, *myService](context.Background(), cb); !errors.Is(err, loadbalance.ErrAllUnhealthy) {
+		t.Fatalf("got %v from a open breaker, want ErrAllUnhealthy", err)
+	}
+}
+
+func TestPickBy(t *testing.T) {
+	ins := getInstance(1)
+	lb := loadbalance.NewRendezvous[string, *myService]()
+	if _, err := loadbalance.PickBy[string, *myService](context.Background(), lb, "key"); !errors.Is(err, loadbalance.ErrNoInstances) {
+		t.Fatalf("got %v from a empty selector, want ErrNoInstances", err)
+	}
+	lb.Add(ins...)
+	first, err := loadbalance.PickBy[string, *myService](context.Background(), lb, "key")
+	if err != nil || first != lb.SelectBy("key") {
+		t.Fatalf("picked %v, %v", first, err)
+	}
+	if sel, err := loadbalance.PickBy[string, *myService](context.Background(), lb, "key", first.Address); err != nil || sel == first {
+		t.Fatalf("picked %v, %v when %s is excluded", sel, err, first.Address)
+	}
+}
+
+func TestReplaceAny(t *testing.T) {
+	ins := getInstance(1)
+	rr := loadbalance.NewRoundRobin[string, *myService]()
+	// the Replace of RoundRobin and the deletes and adds of any other load-balance
+	for _, b := range []loadbalance.Balancer[string, *myService]{rr, plainSelector{rr}} {
+		b.Add(ins[0], ins[1])
+		if n := loadbalance.Replace(b, ins[1], ins[2]); n != 2 || b.Size() != 2 {
+			t.Fatalf("replaced %d instances, %d left", n, b.Size())
+		}
+		if _, ok := b.Get(ins[0].Address); ok {
+			t.Fatal("the instance not in the new set is still there")
+		}
+		loadbalance.Replace(b)
+	}
+}
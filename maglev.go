@@ -0,0 +1,248 @@
+package loadbalance
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/alphadose/haxmap"
+)
+
+// defaultMaglevSize is the default size of the lookup table,
+// it is a prime and should be much larger than the number of instance.
+const defaultMaglevSize = 65537
+
+// Maglev is the consistent hashing of Google's Maglev load-balancer.
+// The instances fill a prime-sized lookup table by their own permutation of it,
+// select is just hashing the key and indexing the table,
+// so it is O(1) with no binary search and no lock.
+//
+// The table is rebuilt on `Add` and `Del` and swapped in atomically,
+// the readers see either the old table or the new one.
+// Every instance owns about the same number of entries, weights are not used.
+type Maglev[T Hashable, I Instance[T]] struct {
+	mutex    sync.Mutex
+	hashmap  *haxmap.Map[T, I]
+	table    atomic.Pointer[[]I]
+	size     uint64
+	hashfunc strHashFunc
+	idhash   func(T) uint64
+}
+
+// NewMaglev returns a Maglev load-balance,
+// the optional argument is the size of the lookup table that is rounded up to a prime,
+// 65537 by default. The table grows to the next prime of the number of instances
+// when there are more, so that every instance owns a entry at least.
+func NewMaglev[T Hashable, I Instance[T]](size ...uint64) *Maglev[T, I] {
+	s := uint64(defaultMaglevSize)
+	if len(size) != 0 && size[0] > 0 {
+		s = nextPrime(size[0])
+	}
+	return &Maglev[T, I]{
+		hashmap:  haxmap.New[T, I](8),
+		size:     s,
+		hashfunc: GetHashFunc[string](),
+		idhash:   GetHashFunc[T](),
+	}
+}
+
+func isPrime(n uint64) bool {
+	if n < 2 {
+		return false
+	}
+	for i := uint64(2); i*i <= n; i++ {
+		if n%i == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func nextPrime(n uint64) uint64 {
+	for !isPrime(n) {
+		n++
+	}
+	return n
+}
+
+// TableSize returns the size of the lookup table
+func (mg *Maglev[T, I]) TableSize() uint64 {
+	mg.mutex.Lock()
+	defer mg.mutex.Unlock()
+	return mg.size
+}
+
+// Add some instances and return the number of successful operation
+func (mg *Maglev[T, I]) Add(instances ...I) int {
+	mg.mutex.Lock()
+	defer mg.mutex.Unlock()
+	count := 0
+	for _, instance := range instances {
+		if _, ok := mg.hashmap.GetOrSet(instance.InstanceID(), instance); !ok {
+			count++
+		}
+	}
+	if count > 0 {
+		mg.rebuild()
+	}
+	return count
+}
+
+// Del some instances and return the number of successful operation
+func (mg *Maglev[T, I]) Del(instances ...I) int {
+	mg.mutex.Lock()
+	defer mg.mutex.Unlock()
+	count := 0
+	for _, instance := range instances {
+		id := instance.InstanceID()
+		if _, ok := mg.hashmap.Get(id); ok {
+			mg.hashmap.Del(id)
+			count++
+		}
+	}
+	if count > 0 {
+		mg.rebuild()
+	}
+	return count
+}
+
+// Replace the instances with the new set in one step
+// and return the number of instances added or deleted,
+// the table is rebuilt once.
+func (mg *Maglev[T, I]) Replace(instances ...I) int {
+	mg.mutex.Lock()
+	defer mg.mutex.Unlock()
+	added, kept, set := splitReplace(instances, func(id T) bool {
+		_, ok := mg.hashmap.Get(id)
+		return ok
+	})
+	var dels []T
+	mg.hashmap.ForEach(func(id T, _ I) bool {
+		if _, ok := set[id]; !ok {
+			dels = append(dels, id)
+		}
+		return true
+	})
+	for _, id := range dels {
+		mg.hashmap.Del(id)
+	}
+	for _, instance := range append(kept, added...) {
+		mg.hashmap.Set(instance.InstanceID(), instance)
+	}
+	mg.rebuild()
+	return len(added) + len(dels)
+}
+
+// rebuild populates a new lookup table and publishes it,
+// the caller must hold the mutex.
+func (mg *Maglev[T, I]) rebuild() {
+	type permutation struct {
+		instance I
+		offset   uint64
+		skip     uint64
+		next     uint64
+		hash     uint64
+	}
+	if n := uint64(mg.hashmap.Len()); mg.size < n {
+		mg.size = nextPrime(n)
+	}
+	perms := make([]*permutation, 0, mg.hashmap.Len())
+	mg.hashmap.ForEach(func(id T, instance I) bool {
+		h := mg.idhash(id)
+		perms = append(perms, &permutation{
+			instance: instance,
+			offset:   h % mg.size,
+			skip:     qwordHasher(h)%(mg.size-1) + 1,
+			hash:     h,
+		})
+		return true
+	})
+	table := make([]I, mg.size)
+	if len(perms) == 0 {
+		mg.table.Store(&table)
+		return
+	}
+	// the table only depends on the set of instances, not on the order they were added
+	sort.Slice(perms, func(i, j int) bool {
+		return perms[i].hash < perms[j].hash
+	})
+
+	filled := make([]bool, mg.size)
+	for n := uint64(0); ; {
+		for _, p := range perms {
+			c := (p.offset + p.next*p.skip) % mg.size
+			for filled[c] {
+				p.next++
+				c = (p.offset + p.next*p.skip) % mg.size
+			}
+			table[c] = p.instance
+			filled[c] = true
+			p.next++
+			n++
+			if n == mg.size {
+				mg.table.Store(&table)
+				return
+			}
+		}
+	}
+}
+
+// Get the value corresponding to the key
+func (mg *Maglev[T, I]) Get(key T) (I, bool) {
+	return haxMapGetVal(mg.hashmap, key)
+}
+
+// ForEach every instances. it is concurrency safe.
+func (mg *Maglev[T, I]) ForEach(callback func(T, I) bool) {
+	haxMapForEach(mg.hashmap, callback)
+}
+
+func (mg *Maglev[T, I]) Size() int {
+	return int(mg.hashmap.Len())
+}
+
+// SelectBy returns the instance of the table entry the key is hashed to
+func (mg *Maglev[T, I]) SelectBy(key string) (ins I) {
+	table := mg.table.Load()
+	if table == nil {
+		return
+	}
+	return (*table)[mg.hashfunc(key)%uint64(len(*table))]
+}
+
+// PickBy selects a instance for the key like `SelectBy`, or returns `ErrNoInstances` if there is none
+func (mg *Maglev[T, I]) PickBy(ctx context.Context, key string) (I, error) {
+	return pick(ctx, func() (I, bool) { return mg.selectByExcluding(key, nil) }, nil)
+}
+
+// SelectByExcluding returns the instance of the table entry the key is hashed to,
+// or of the following entries when it is excluded.
+func (mg *Maglev[T, I]) SelectByExcluding(key string, exclude ...T) I {
+	ins, _ := mg.selectByExcluding(key, exclude)
+	return ins
+}
+
+func (mg *Maglev[T, I]) selectByExcluding(key string, exclude []T) (ins I, ok bool) {
+	table := mg.table.Load()
+	// the table of no instance is all zero values
+	if table == nil || isZero[T]((*table)[0]) {
+		return
+	}
+	// the size may grow with the table, read it from the table loaded
+	size := uint64(len(*table))
+	h := mg.hashfunc(key) % size
+	for i := uint64(0); i < size; i++ {
+		ins = (*table)[(h+i)%size]
+		if !excluded(exclude, ins.InstanceID()) {
+			return ins, true
+		}
+	}
+	return *new(I), false
+}
+
+// SelectNBy returns at most n distinct instances for the key,
+// the instances of the entry the key is hashed to and of the following entries.
+func (mg *Maglev[T, I]) SelectNBy(key string, n int) []I {
+	return selectNBy[T, I](mg, key, n)
+}
@@ -0,0 +1,130 @@
+package loadbalance_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ydmxcz/loadbalance"
+)
+
+func TestMaglevTableSize(t *testing.T) {
+	if s := loadbalance.NewMaglev[string, *myService](1000).TableSize(); s != 1009 {
+		t.Fatalf("table size is %d, want the prime 1009", s)
+	}
+	lb := loadbalance.NewMaglev[string, *myService]()
+	if lb.SelectBy("key") != nil {
+		t.Fatal("empty balancer selected a instance")
+	}
+}
+
+func TestMaglevSmallTable(t *testing.T) {
+	lb := loadbalance.NewMaglev[string, *myService](2)
+	ins := getInstance(1)
+	lb.Add(ins...)
+	if s := lb.TableSize(); s < uint64(len(ins)) {
+		t.Fatalf("table size is %d, want %d at least", s, len(ins))
+	}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if sel := lb.SelectNBy(key, lb.Size()); len(sel) != len(ins) {
+			t.Fatalf("selected %d instances for %s, want %d", len(sel), key, len(ins))
+		}
+	}
+}
+
+func TestMaglevBalanceAndDisruption(t *testing.T) {
+	var lb loadbalance.SelectorBy[string, *myService] = loadbalance.NewMaglev[string, *myService](4099)
+	ins := getInstance(2)[:16]
+	lb.Add(ins...)
+
+	before := map[string]*myService{}
+	m := map[*myService]int{}
+	for i := 0; i < 16000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		sel := lb.SelectBy(key)
+		before[key] = sel
+		m[sel]++
+	}
+	for _, in := range ins {
+		if m[in] < 700 || m[in] > 1300 {
+			t.Fatalf("%s got %d keys, want about 1000", in.Address, m[in])
+		}
+	}
+
+	lb.Del(ins[3])
+	moved := 0
+	for key, old := range before {
+		sel := lb.SelectBy(key)
+		if sel == ins[3] {
+			t.Fatal("selected a deleted instance")
+		}
+		if old != ins[3] && sel != old {
+			moved++
+		}
+	}
+	// maglev trades a little disruption for balance
+	if moved > len(before)/20 {
+		t.Fatalf("%d keys of remaining instances moved", moved)
+	}
+}
+
+func TestMaglevSelectByExcluding(t *testing.T) {
+	lb := loadbalance.NewMaglev[string, *myService](1031)
+	ins := getInstance(1)
+	lb.Add(ins...)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		first := lb.SelectBy(key)
+		for _, gone := range ins {
+			sel := lb.SelectByExcluding(key, gone.Address)
+			// only the keys of the excluded instance move, always to the same one
+			if first != gone && sel != first {
+				t.Fatalf("key %s moved from %s to %v", key, first.Address, sel)
+			}
+			if sel == nil || sel == gone || sel != lb.SelectByExcluding(key, gone.Address) {
+				t.Fatalf("key %s went to %v without %s", key, sel, gone.Address)
+			}
+		}
+	}
+	if sel := lb.SelectByExcluding("key", ins[0].Address, ins[1].Address, ins[2].Address); sel != nil {
+		t.Fatalf("got %s when all are excluded", sel.Address)
+	}
+}
+
+func TestMaglevSelectNBy(t *testing.T) {
+	checkReplicas(t, loadbalance.NewMaglev[string, *myService](1031))
+}
+
+func TestMaglevPickBy(t *testing.T) {
+	lb := loadbalance.NewMaglev[string, *myService](1031)
+	if _, err := lb.PickBy(context.Background(), "key"); !errors.Is(err, loadbalance.ErrNoInstances) {
+		t.Fatalf("got %v, want ErrNoInstances", err)
+	}
+	lb.Add(getInstance(1)...)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if sel, err := lb.PickBy(context.Background(), key); err != nil || sel != lb.SelectBy(key) {
+			t.Fatalf("picked %v, %v for key %s, want %s", sel, err, key, lb.SelectBy(key).Address)
+		}
+	}
+}
+
+func TestMaglevReplace(t *testing.T) {
+	lb := loadbalance.NewMaglev[string, *myService](1031)
+	ins := getInstance(1)
+	lb.Add(ins[0], ins[1])
+	if n := lb.Replace(ins[1], ins[2]); n != 2 || lb.Size() != 2 {
+		t.Fatalf("replaced %d instances, %d left", n, lb.Size())
+	}
+	// the table is the one of a Maglev of the new set
+	want := loadbalance.NewMaglev[string, *myService](1031)
+	want.Add(ins[1], ins[2])
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if sel := lb.SelectBy(key); sel != want.SelectBy(key) {
+			t.Fatalf("key %s went to %v after replace", key, sel)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package loadbalance
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// OutlierConfig is the config of OutlierDetector,
+// the zero value of a field means its default value.
+type OutlierConfig struct {
+	// ConsecutiveErrors ejects a instance after this number of consecutive failures, 5 by default
+	ConsecutiveErrors int
+	// ErrorRate ejects a instance whose rate of failure in the last `MinRequests` requests
+	// reaches it, 0 disables the check by rate
+	ErrorRate float64
+	// MinRequests is the number of requests the error rate is computed over, 100 by default
+	MinRequests int
+	// BaseEjectionTime is the ejection time of the first ejection,
+	// it is multiplied by the number of times the instance was ejected, 30 seconds by default
+	BaseEjectionTime time.Duration
+	// MaxEjectionTime caps the ejection time, 300 seconds by default
+	MaxEjectionTime time.Duration
+	// MaxEjectionPercent caps the percent of instances ejected at the same time,
+	// at least one instance can always be ejected, 10 by default
+	MaxEjectionPercent int
+}
+
+func (oc *OutlierConfig) setDefault() {
+	if oc.ConsecutiveErrors <= 0 {
+		oc.ConsecutiveErrors = 5
+	}
+	if oc.MinRequests <= 0 {
+		oc.MinRequests = 100
+	}
+	if oc.BaseEjectionTime <= 0 {
+		oc.BaseEjectionTime = 30 * time.Second
+	}
+	if oc.MaxEjectionTime <= 0 {
+		oc.MaxEjectionTime = 300 * time.Second
+	}
+	if oc.MaxEjectionTime < oc.BaseEjectionTime {
+		oc.MaxEjectionTime = oc.BaseEjectionTime
+	}
+	if oc.MaxEjectionPercent <= 0 {
+		oc.MaxEjectionPercent = 10
+	}
+}
+
+// `outlierState` is a registered instance and the results reported for it
+type outlierState[T Hashable, I Instance[T]] struct {
+	instance    I
+	consecutive int
+	requests    int
+	errors      int
+	ejections   int
+	ejected     bool
+	returned    time.Time
+	timer       *time.Timer
+}
+
+// OutlierDetector is the passive outlier detection of Envoy in front of a Selector.
+// Callers report the result of every request by `Report`,
+// a instance with `ConsecutiveErrors` consecutive failures
+// or a error rate over `ErrorRate` is ejected by deleting it from the target
+// and added back after the ejection time.
+// The ejection time grows with the number of times the instance was ejected,
+// and it is reset once the instance stayed in the target for `MaxEjectionTime`.
+//
+// It works with any Selector without changing it,
+// instances are added to and deleted from the OutlierDetector instead of the target.
+// `Done` and `Observe` are passed to the target, so a target such as `P2C` or `PeakEWMA`
+// keeps counting the outstanding requests and estimating the latency.
+type OutlierDetector[T Hashable, I Instance[T]] struct {
+	mutex    sync.Mutex
+	target   Selector[T, I]
+	config   OutlierConfig
+	registry map[T]*outlierState[T, I]
+	ejected  int
+}
+
+func NewOutlierDetector[T Hashable, I Instance[T]](target Selector[T, I], config ...OutlierConfig) *OutlierDetector[T, I] {
+	var c OutlierConfig
+	if len(config) != 0 {
+		c = config[0]
+	}
+	c.setDefault()
+	return &OutlierDetector[T, I]{
+		target:   target,
+		config:   c,
+		registry: make(map[T]*outlierState[T, I]),
+	}
+}
+
+// Add registers some instances and adds them to the target,
+// return the number of successful operation
+func (od *OutlierDetector[T, I]) Add(instances ...I) int {
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+	count := 0
+	for _, instance := range instances {
+		id := instance.InstanceID()
+		if _, ok := od.registry[id]; !ok {
+			od.registry[id] = &outlierState[T, I]{instance: instance}
+			od.target.Add(instance)
+			count++
+		}
+	}
+	return count
+}
+
+// Del unregisters some instances and deletes them from the target,
+// return the number of successful operation
+func (od *OutlierDetector[T, I]) Del(instances ...I) int {
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+	count := 0
+	for _, instance := range instances {
+		id := instance.InstanceID()
+		s, ok := od.registry[id]
+		if !ok {
+			continue
+		}
+		delete(od.registry, id)
+		if s.ejected {
+			s.timer.Stop()
+			od.ejected--
+		} else {
+			od.target.Del(s.instance)
+		}
+		count++
+	}
+	return count
+}
+
+// Replace the registered instances with the new set in one step
+// and return the number of instances added or deleted.
+// The instances kept keep their results and ejection,
+// the target is replaced with the instances not ejected.
+func (od *OutlierDetector[T, I]) Replace(instances ...I) int {
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+	added, kept, set := splitReplace(instances, func(id T) bool {
+		_, ok := od.registry[id]
+		return ok
+	})
+	count := len(added)
+	for id, s := range od.registry {
+		if _, ok := set[id]; ok {
+			continue
+		}
+		delete(od.registry, id)
+		if s.ejected {
+			s.timer.Stop()
+			od.ejected--
+		}
+		count++
+	}
+	active := make([]I, 0, len(set))
+	for _, instance := range kept {
+		s := od.registry[instance.InstanceID()]
+		s.instance = instance
+		if !s.ejected {
+			active = append(active, instance)
+		}
+	}
+	for _, instance := range added {
+		od.registry[instance.InstanceID()] = &outlierState[T, I]{instance: instance}
+		active = append(active, instance)
+	}
+	Replace[T, I](od.target, active...)
+	return count
+}
+
+// Get a registered instance whether it is ejected or not
+func (od *OutlierDetector[T, I]) Get(key T) (ins I, ok bool) {
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+	if s, ok := od.registry[key]; ok {
+		return s.instance, true
+	}
+	return
+}
+
+// ForEach every registered instances whether it is ejected or not
+func (od *OutlierDetector[T, I]) ForEach(callback func(T, I) bool) {
+	od.mutex.Lock()
+	instances := make([]I, 0, len(od.registry))
+	for _, s := range od.registry {
+		instances = append(instances, s.instance)
+	}
+	od.mutex.Unlock()
+	for _, ins := range instances {
+		if !callback(ins.InstanceID(), ins) {
+			return
+		}
+	}
+}
+
+// Size returns the number of registered instances
+func (od *OutlierDetector[T, I]) Size() int {
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+	return len(od.registry)
+}
+
+// Select a instance from the target, ejected instances are never selected
+func (od *OutlierDetector[T, I]) Select() I {
+	return od.target.Select()
+}
+
+// Pick selects a instance like `Select`, it returns `ErrAllUnhealthy`
+// if every instance is ejected and `ErrNoInstances` if there is none
+func (od *OutlierDetector[T, I]) Pick(ctx context.Context) (I, error) {
+	return pick(ctx, func() (I, bool) { return od.selectExcluding(nil) }, od.Size)
+}
+
+// SelectN selects at most n distinct instances from the target
+func (od *OutlierDetector[T, I]) SelectN(n int) []I {
+	return selectN[T, I](od, n)
+}
+
+// SelectExcluding selects a instance from the target other than the excluded ones
+func (od *OutlierDetector[T, I]) SelectExcluding(exclude ...T) I {
+	ins, _ := od.selectExcluding(exclude)
+	return ins
+}
+
+func (od *OutlierDetector[T, I]) selectExcluding(exclude []T) (I, bool) {
+	return selectExcluding(od.target, exclude)
+}
+
+// Ejected returns whether the instance is ejected now
+func (od *OutlierDetector[T, I]) Ejected(key T) bool {
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+	s, ok := od.registry[key]
+	return ok && s.ejected
+}
+
+// Done passes the end of a request sent to the instance to the target if it is `LoadAware`
+func (od *OutlierDetector[T, I]) Done(ins I) {
+	if la, ok := od.target.(LoadAware[T, I]); ok {
+		la.Done(ins)
+	}
+}
+
+// Observe passes the latency of a request sent to the instance to the target if it is a `Observer`
+func (od *OutlierDetector[T, I]) Observe(ins I, rtt time.Duration) {
+	if o, ok := od.target.(Observer[T, I]); ok {
+		o.Observe(ins, rtt)
+	}
+}
+
+// Report the result of a request sent to the instance, a nil err means success
+func (od *OutlierDetector[T, I]) Report(ins I, err error) {
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+	s, ok := od.registry[ins.InstanceID()]
+	if !ok || s.ejected {
+		return
+	}
+	s.requests++
+	if err == nil {
+		s.consecutive = 0
+	} else {
+		s.consecutive++
+		s.errors++
+	}
+	eject := s.consecutive >= od.config.ConsecutiveErrors
+	if s.requests >= od.config.MinRequests {
+		if od.config.ErrorRate > 0 && float64(s.errors)/float64(s.requests) >= od.config.ErrorRate {
+			eject = true
+		}
+		s.requests, s.errors = 0, 0
+	}
+	if eject {
+		od.eject(s)
+	}
+}
+
+// eject the instance if the max ejection percent allows,
+// the caller must hold the mutex.
+func (od *OutlierDetector[T, I]) eject(s *outlierState[T, I]) {
+	allowed := len(od.registry) * od.config.MaxEjectionPercent / 100
+	if allowed < 1 {
+		allowed = 1
+	}
+	if od.ejected >= allowed {
+		return
+	}
+	if !s.returned.IsZero() && time.Since(s.returned) >= od.config.MaxEjectionTime {
+		s.ejections = 0
+	}
+	s.ejections++
+	d := od.config.BaseEjectionTime * time.Duration(s.ejections)
+	if d > od.config.MaxEjectionTime || d <= 0 {
+		d = od.config.MaxEjectionTime
+	}
+	s.ejected = true
+	s.consecutive, s.requests, s.errors = 0, 0, 0
+	od.ejected++
+	od.target.Del(s.instance)
+	s.timer = time.AfterFunc(d, func() {
+		od.uneject(s)
+	})
+}
+
+// uneject adds the instance back to the target when its ejection time is over
+func (od *OutlierDetector[T, I]) uneject(s *outlierState[T, I]) {
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+	// deleted or replaced during the ejection
+	if od.registry[s.instance.InstanceID()] != s || !s.ejected {
+		return
+	}
+	s.ejected = false
+	s.returned = time.Now()
+	od.ejected--
+	od.target.Add(s.instance)
+}
@@ -0,0 +1,186 @@
+package loadbalance_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ydmxcz/loadbalance"
+)
+
+func TestOutlierDetectorConsecutiveErrors(t *testing.T) {
+	errFailed := errors.New("failed")
+	od := loadbalance.NewOutlierDetector[string, *myService](loadbalance.NewDynamicWeighted[string, *myService](),
+		loadbalance.OutlierConfig{
+			ConsecutiveErrors:  3,
+			BaseEjectionTime:   50 * time.Millisecond,
+			MaxEjectionPercent: 50,
+		})
+	ins := getInstance(1)
+	od.Add(ins...)
+
+	od.Report(ins[0], errFailed)
+	od.Report(ins[0], errFailed)
+	od.Report(ins[0], nil)
+	od.Report(ins[0], errFailed)
+	od.Report(ins[0], errFailed)
+	if od.Ejected(ins[0].Address) {
+		t.Fatal("a success did not reset the consecutive errors")
+	}
+	od.Report(ins[0], errFailed)
+	if !od.Ejected(ins[0].Address) {
+		t.Fatal("instance was not ejected")
+	}
+	for i := 0; i < 100; i++ {
+		if od.Select() == ins[0] {
+			t.Fatal("selected a ejected instance")
+		}
+	}
+
+	// only one of three instances can be ejected at the same time
+	for i := 0; i < 3; i++ {
+		od.Report(ins[1], errFailed)
+	}
+	if od.Ejected(ins[1].Address) {
+		t.Fatal("max ejection percent was exceeded")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if od.Ejected(ins[0].Address) {
+		t.Fatal("instance was not returned after the ejection time")
+	}
+	if od.Size() != 3 {
+		t.Fatal("registry lost a instance")
+	}
+}
+
+func TestOutlierDetectorErrorRate(t *testing.T) {
+	errFailed := errors.New("failed")
+	od := loadbalance.NewOutlierDetector[string, *myService](loadbalance.NewRoundRobin[string, *myService](),
+		loadbalance.OutlierConfig{
+			ErrorRate:   0.5,
+			MinRequests: 10,
+		})
+	ins := getInstance(1)
+	od.Add(ins...)
+	for i := 0; i < 10; i++ {
+		if i%2 == 0 {
+			od.Report(ins[2], errFailed)
+		} else {
+			od.Report(ins[2], nil)
+		}
+	}
+	if !od.Ejected(ins[2].Address) {
+		t.Fatal("instance was not ejected by error rate")
+	}
+	od.Del(ins[2])
+	if od.Size() != 2 || od.Ejected(ins[2].Address) {
+		t.Fatal("delete of a ejected instance failed")
+	}
+}
+
+func TestOutlierDetectorSelectExcluding(t *testing.T) {
+	od := loadbalance.NewOutlierDetector[string, *myService](loadbalance.NewRoundRobin[string, *myService](),
+		loadbalance.OutlierConfig{ConsecutiveErrors: 1, BaseEjectionTime: time.Minute, MaxEjectionPercent: 50})
+	ins := getInstance(1)
+	od.Add(ins...)
+	od.Report(ins[0], errors.New("failed"))
+
+	// neither the ejected nor the excluded instance is selected
+	for i := 0; i < 100; i++ {
+		if sel := od.SelectExcluding(ins[1].Address); sel != ins[2] {
+			t.Fatalf("selected %v, want %s", sel, ins[2].Address)
+		}
+	}
+	if sel := od.SelectExcluding(ins[1].Address, ins[2].Address); sel != nil {
+		t.Fatalf("selected %s, the rest instance is ejected", sel.Address)
+	}
+}
+
+func TestOutlierDetectorSelectN(t *testing.T) {
+	od := loadbalance.NewOutlierDetector[string, *myService](loadbalance.NewRandom[string, *myService](),
+		loadbalance.OutlierConfig{ConsecutiveErrors: 1, BaseEjectionTime: time.Minute, MaxEjectionPercent: 50})
+	ins := getInstance(1)
+	od.Add(ins...)
+	od.Report(ins[0], errors.New("failed"))
+	for i := 0; i < 100; i++ {
+		res := od.SelectN(3)
+		checkDistinct(t, res, 2)
+		if res[0] == ins[0] || res[1] == ins[0] {
+			t.Fatalf("selected the ejected %s", ins[0].Address)
+		}
+	}
+}
+
+func TestOutlierDetectorPick(t *testing.T) {
+	od := loadbalance.NewOutlierDetector[string, *myService](loadbalance.NewRoundRobin[string, *myService](),
+		loadbalance.OutlierConfig{
+			ConsecutiveErrors:  1,
+			BaseEjectionTime:   time.Minute,
+			MaxEjectionPercent: 100,
+		})
+	if _, err := od.Pick(context.Background()); !errors.Is(err, loadbalance.ErrNoInstances) {
+		t.Fatalf("got %v, want ErrNoInstances", err)
+	}
+	ins := getInstance(1)
+	od.Add(ins...)
+	for _, in := range ins {
+		od.Report(in, errors.New("failed"))
+	}
+	if _, err := od.Pick(context.Background()); !errors.Is(err, loadbalance.ErrAllUnhealthy) {
+		t.Fatalf("got %v, want ErrAllUnhealthy", err)
+	}
+	od.Del(ins...)
+	if _, err := od.Pick(context.Background()); !errors.Is(err, loadbalance.ErrNoInstances) {
+		t.Fatalf("got %v after delete, want ErrNoInstances", err)
+	}
+}
+
+func TestOutlierDetectorReplace(t *testing.T) {
+	errFailed := errors.New("failed")
+	od := loadbalance.NewOutlierDetector[string, *myService](loadbalance.NewRoundRobin[string, *myService](),
+		loadbalance.OutlierConfig{ConsecutiveErrors: 1, BaseEjectionTime: time.Minute, MaxEjectionPercent: 50})
+	ins := getInstance(1)
+	od.Add(ins[0], ins[1])
+	od.Report(ins[0], errFailed)
+	if n := od.Replace(ins...); n != 1 || od.Size() != 3 {
+		t.Fatalf("replaced %d instances, %d registered", n, od.Size())
+	}
+	// the kept instance stays ejected until its ejection time is over
+	if !od.Ejected(ins[0].Address) {
+		t.Fatal("a kept instance is not ejected anymore")
+	}
+	m := map[string]int{}
+	for i := 0; i < 100; i++ {
+		m[od.Select().Address]++
+	}
+	if m[ins[0].Address] != 0 || m[ins[1].Address] != 50 || m[ins[2].Address] != 50 {
+		t.Fatalf("got %v after replace", m)
+	}
+	checkReplaceAtomic(t, loadbalance.NewOutlierDetector[string, *myService](loadbalance.NewRoundRobin[string, *myService]()))
+}
+
+func TestOutlierDetectorLoadAware(t *testing.T) {
+	pc := loadbalance.NewP2C[string, *myService]()
+	od := loadbalance.NewOutlierDetector[string, *myService](pc)
+	ins := getInstance(1)
+	od.Add(ins...)
+	for i := 0; i < 30; i++ {
+		// the detector passes it to the target
+		od.Done(od.Select())
+	}
+	for _, in := range ins {
+		if n := pc.Inflight(in.Address); n != 0 {
+			t.Fatalf("%s has %d outstanding requests", in.Address, n)
+		}
+	}
+
+	pe := loadbalance.NewPeakEWMA[string, *myService]()
+	od = loadbalance.NewOutlierDetector[string, *myService](pe)
+	od.Add(ins...)
+	od.Observe(ins[0], time.Second)
+	if e := pe.Estimate(ins[0].Address); e < 500*time.Millisecond {
+		t.Fatalf("the estimate of %s is %v after a latency of 1s passed by the detector", ins[0].Address, e)
+	}
+}
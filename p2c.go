@@ -0,0 +1,249 @@
+package loadbalance
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alphadose/haxmap"
+)
+
+// `loadNode` wraps an instance with the number of requests
+// that have been sent to it and not finished yet.
+type loadNode[T Hashable, I Instance[T]] struct {
+	instance I
+	inflight int64
+}
+
+// decrementInflight subtracts one from the counter but never lets it go below zero,
+// a stale `Done` for an instance that was deleted and re-added must not
+// make the new node look less loaded than it is.
+func decrementInflight(p *int64) {
+	for {
+		v := atomic.LoadInt64(p)
+		if v <= 0 || atomic.CompareAndSwapInt64(p, v, v-1) {
+			return
+		}
+	}
+}
+
+// P2C is the "power of two choices" load-balance.
+// Every `Select` samples two distinct instances at random
+// and returns the one with fewer outstanding requests,
+// so the time complexity of select is O(1) whatever the number of instance is.
+//
+// The number of outstanding requests is counted by the balancer itself:
+// `Select` adds one to the chosen instance and `Done` subtracts it again,
+// so every instance returned by `Select` must be passed to `Done`
+// once the request sent to it has finished.
+// `SelectWithDone` does both and returns the second half as a callback.
+type P2C[T Hashable, I Instance[T]] struct {
+	mutex   sync.RWMutex
+	hashmap *haxmap.Map[T, *loadNode[T, I]]
+	nodes   []*loadNode[T, I]
+	random  XorShift64
+}
+
+func NewP2C[T Hashable, I Instance[T]]() *P2C[T, I] {
+	return &P2C[T, I]{
+		hashmap: haxmap.New[T, *loadNode[T, I]](8),
+		nodes:   make([]*loadNode[T, I], 0, 8),
+		random:  NewXorShift64(uint64(time.Now().UnixNano())),
+	}
+}
+
+// Add some instances and return the number of successful operation
+func (pc *P2C[T, I]) Add(instances ...I) int {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	count := 0
+	for _, instance := range instances {
+		id := instance.InstanceID()
+		if _, ok := pc.hashmap.Get(id); !ok {
+			n := &loadNode[T, I]{instance: instance}
+			pc.hashmap.Set(id, n)
+			pc.nodes = append(pc.nodes, n)
+			count++
+		}
+	}
+	return count
+}
+
+// Del some instances and return the number of successful operation
+func (pc *P2C[T, I]) Del(instances ...I) int {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	count := 0
+	for _, instance := range instances {
+		id := instance.InstanceID()
+		if _, ok := pc.hashmap.Get(id); ok {
+			for i := 0; i < len(pc.nodes); i++ {
+				if pc.nodes[i].instance.InstanceID() == id {
+					pc.hashmap.Del(id)
+					pc.nodes = append(pc.nodes[:i], pc.nodes[i+1:]...)
+					break
+				}
+			}
+			count++
+		}
+	}
+	return count
+}
+
+// Replace the instances with the new set in one step
+// and return the number of instances added or deleted,
+// the instances kept are updated with their new value and keep their outstanding requests.
+func (pc *P2C[T, I]) Replace(instances ...I) int {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	var count int
+	pc.nodes, count = replaceNodes(pc.hashmap, pc.nodes, instances,
+		(*loadNode[T, I]).id,
+		func(instance I) *loadNode[T, I] { return &loadNode[T, I]{instance: instance} },
+		func(n *loadNode[T, I], instance I) *loadNode[T, I] {
+			n.instance = instance
+			return n
+		})
+	return count
+}
+
+// Get the value corresponding to the key
+func (pc *P2C[T, I]) Get(key T) (ins I, ok bool) {
+	pc.mutex.RLock()
+	defer pc.mutex.RUnlock()
+	if n, ok := haxMapGetVal(pc.hashmap, key); ok {
+		return n.instance, true
+	}
+	return
+}
+
+// ForEach every instances. it is concurrency safe,
+// the callback is called on a copy of the instances taken under the lock.
+func (pc *P2C[T, I]) ForEach(callback func(T, I) bool) {
+	pc.mutex.RLock()
+	instances := make([]I, len(pc.nodes))
+	for i, n := range pc.nodes {
+		instances[i] = n.instance
+	}
+	pc.mutex.RUnlock()
+	for _, instance := range instances {
+		if !callback(instance.InstanceID(), instance) {
+			return
+		}
+	}
+}
+
+func (pc *P2C[T, I]) Size() int {
+	return int(pc.hashmap.Len())
+}
+
+// Inflight returns the number of outstanding requests of the instance.
+func (pc *P2C[T, I]) Inflight(key T) int64 {
+	if n, ok := pc.hashmap.Get(key); ok {
+		return atomic.LoadInt64(&n.inflight)
+	}
+	return 0
+}
+
+// Select a instance and count a new outstanding request on it
+func (pc *P2C[T, I]) Select() (ins I) {
+	ins, _ = pc.selectNode(nil)
+	return
+}
+
+// Pick selects a instance like `Select`, or returns `ErrNoInstances` if there is none
+func (pc *P2C[T, I]) Pick(ctx context.Context) (I, error) {
+	return pick(ctx, func() (I, bool) { return pc.selectExcluding(nil) }, nil)
+}
+
+// SelectN selects at most n distinct instances like `Select`,
+// every one of them must be passed to `Done`
+func (pc *P2C[T, I]) SelectN(n int) []I {
+	return selectN[T, I](pc, n)
+}
+
+// SelectExcluding selects a instance like `Select` other than the excluded ones
+func (pc *P2C[T, I]) SelectExcluding(exclude ...T) I {
+	ins, _ := pc.selectExcluding(exclude)
+	return ins
+}
+
+func (pc *P2C[T, I]) selectExcluding(exclude []T) (ins I, ok bool) {
+	ins, n := pc.selectNode(exclude)
+	return ins, n != nil
+}
+
+// SelectWithDone selects a instance like `Select` and returns a callback
+// that finishes the request on it. The callback is safe to be called more than once.
+func (pc *P2C[T, I]) SelectWithDone() (ins I, done func()) {
+	ins, n := pc.selectNode(nil)
+	if n == nil {
+		return ins, func() {}
+	}
+	return ins, onceDone(func() { decrementInflight(&n.inflight) })
+}
+
+// Done reports that a request sent to the instance has finished
+func (pc *P2C[T, I]) Done(ins I) {
+	if n, ok := pc.hashmap.Get(ins.InstanceID()); ok {
+		decrementInflight(&n.inflight)
+	}
+}
+
+// selectNode returns the instance of the node as well,
+// it is read under the lock since `Replace` updates the kept instances.
+func (pc *P2C[T, I]) selectNode(exclude []T) (ins I, n *loadNode[T, I]) {
+	pc.mutex.RLock()
+	defer pc.mutex.RUnlock()
+	nodes := pc.nodes
+	if len(exclude) != 0 {
+		nodes = excludeNodes(nodes, exclude)
+	}
+	size := uint64(len(nodes))
+	if size == 0 {
+		return
+	}
+	n = nodes[0]
+	if size > 1 {
+		i := pc.random.Uint64() % size
+		j := pc.random.Uint64() % (size - 1)
+		if j >= i {
+			j++
+		}
+		a, b := nodes[i], nodes[j]
+		if atomic.LoadInt64(&b.inflight) < atomic.LoadInt64(&a.inflight) {
+			n = b
+		} else {
+			n = a
+		}
+	}
+	atomic.AddInt64(&n.inflight, 1)
+	return n.instance, n
+}
+
+// excludeNodes returns a copy of nodes without the excluded instances
+func excludeNodes[T Hashable, N interface{ id() T }](nodes []N, exclude []T) []N {
+	res := make([]N, 0, len(nodes))
+	for _, n := range nodes {
+		if !excluded(exclude, n.id()) {
+			res = append(res, n)
+		}
+	}
+	return res
+}
+
+func (n *loadNode[T, I]) id() T {
+	return n.instance.InstanceID()
+}
+
+// onceDone makes a done callback idempotent,
+// callers usually `defer` it and call it on the error path as well.
+func onceDone(done func()) func() {
+	var called int32
+	return func() {
+		if atomic.CompareAndSwapInt32(&called, 0, 1) {
+			done()
+		}
+	}
+}
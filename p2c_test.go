@@ -0,0 +1,132 @@
+package loadbalance_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ydmxcz/loadbalance"
+)
+
+func TestP2CAvoidsLoadedInstance(t *testing.T) {
+	var lb loadbalance.LoadAware[string, *myService] = loadbalance.NewP2C[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins[0], ins[1])
+
+	// keep ins[0] busy, every pair sampled contains both instances
+	busy := ins[0]
+	for lb.Select() != busy {
+		lb.Done(ins[1])
+	}
+	for i := 0; i < 100; i++ {
+		if lb.Select() == busy {
+			t.Fatal("selected the instance with more outstanding requests")
+		}
+		lb.Done(ins[1])
+	}
+	lb.Done(busy)
+	if n := lb.(*loadbalance.P2C[string, *myService]).Inflight(busy.InstanceID()); n != 0 {
+		t.Fatalf("inflight of %s is %d, want 0", busy.InstanceID(), n)
+	}
+}
+
+func TestP2CSelectWithDone(t *testing.T) {
+	lb := loadbalance.NewP2C[string, *myService]()
+	if _, done := lb.SelectWithDone(); done == nil {
+		t.Fatal("done callback of an empty balancer is nil")
+	}
+	lb.Add(getInstance(1)...)
+	ins, done := lb.SelectWithDone()
+	done()
+	done()
+	if n := lb.Inflight(ins.InstanceID()); n != 0 {
+		t.Fatalf("inflight of %s is %d, want 0", ins.InstanceID(), n)
+	}
+}
+
+func TestP2CSelectExcluding(t *testing.T) {
+	lb := loadbalance.NewP2C[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins...)
+
+	// keep ins[1] busy, the two choices left are ins[1] and ins[2]
+	if sel := lb.SelectExcluding(ins[0].Address, ins[2].Address); sel != ins[1] {
+		t.Fatalf("selected %v, want %s", sel, ins[1].Address)
+	}
+	for i := 0; i < 100; i++ {
+		sel := lb.SelectExcluding(ins[0].Address)
+		if sel != ins[2] {
+			t.Fatalf("selected %v, want the idle %s", sel, ins[2].Address)
+		}
+		lb.Done(sel)
+	}
+	if sel := lb.SelectExcluding(ins[0].Address, ins[1].Address, ins[2].Address); sel != nil {
+		t.Fatalf("got %s when all are excluded", sel.Address)
+	}
+}
+
+func TestP2CSelectN(t *testing.T) {
+	lb := loadbalance.NewP2C[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins...)
+
+	// keep ins[0] busy, the busy one loses every pair it is sampled in
+	busy := lb.SelectExcluding(ins[1].Address, ins[2].Address)
+	for i := 0; i < 100; i++ {
+		res := lb.SelectN(2)
+		checkDistinct(t, res, 2)
+		for _, in := range res {
+			if in == busy {
+				t.Fatalf("selected the busy %s", busy.Address)
+			}
+			lb.Done(in)
+		}
+	}
+}
+
+func TestP2CPick(t *testing.T) {
+	lb := loadbalance.NewP2C[string, *myService]()
+	if _, err := lb.Pick(context.Background()); !errors.Is(err, loadbalance.ErrNoInstances) {
+		t.Fatalf("got %v, want ErrNoInstances", err)
+	}
+	lb.Add(getInstance(1)...)
+
+	// a picked instance is outstanding until `Done` like a selected one
+	sel, err := lb.Pick(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := lb.Inflight(sel.Address); n != 1 {
+		t.Fatalf("inflight of %s is %d after pick, want 1", sel.Address, n)
+	}
+	lb.Done(sel)
+	if n := lb.Inflight(sel.Address); n != 0 {
+		t.Fatalf("inflight of %s is %d after done, want 0", sel.Address, n)
+	}
+}
+
+func TestP2CReplace(t *testing.T) {
+	lb := loadbalance.NewP2C[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins[0], ins[1])
+	lb.SelectN(2)
+	updated := &myService{Address: ins[1].Address, Memory: 7}
+	if n := lb.Replace(updated, ins[2]); n != 2 || lb.Size() != 2 {
+		t.Fatalf("replaced %d instances, %d left", n, lb.Size())
+	}
+	// the kept instance is the new value and keeps its outstanding request
+	if got, ok := lb.Get(ins[1].Address); !ok || got != updated {
+		t.Fatalf("got %v, want the new value of the kept instance", got)
+	}
+	if n := lb.Inflight(ins[1].Address); n != 1 {
+		t.Fatalf("%d outstanding requests of the kept instance, want 1", n)
+	}
+	for i := 0; i < 100; i++ {
+		sel := lb.Select()
+		if sel != updated && sel != ins[2] {
+			t.Fatalf("selected %v after replace", sel)
+		}
+		lb.Done(sel)
+	}
+	checkReplaceAtomic(t, loadbalance.NewP2C[string, *myService]())
+}
@@ -0,0 +1,302 @@
+package loadbalance
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alphadose/haxmap"
+)
+
+const (
+	// defaultDecayTime is the time it takes a latency peak to decay to 1/e of itself
+	defaultDecayTime = 10 * time.Second
+	// defaultRTT is the latency estimate of a instance that has not been observed yet
+	defaultRTT = 30 * time.Millisecond
+	// penalty is the cost of a instance whose estimate decayed to zero but still has outstanding requests
+	penalty = float64(math.MaxInt64 >> 16)
+)
+
+// `ewmaNode` wraps an instance with its number of outstanding requests
+// and its latency estimate in nanosecond.
+type ewmaNode[T Hashable, I Instance[T]] struct {
+	instance I
+	inflight int64
+	mutex    sync.Mutex
+	cost     float64
+	stamp    int64
+}
+
+// observe folds a latency sample into the estimate,
+// a sample larger than the estimate replaces it at once (the "peak"),
+// otherwise the estimate decays towards the sample.
+func (n *ewmaNode[T, I]) observe(rtt float64, now int64, tau float64) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	elapsed := float64(now - n.stamp)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	n.stamp = now
+	if rtt > n.cost {
+		n.cost = rtt
+	} else {
+		w := math.Exp(-elapsed / tau)
+		n.cost = n.cost*w + rtt*(1-w)
+	}
+}
+
+// load is `estimate * (inflight+1)`, the estimate decays towards zero
+// while no sample was observed, so an idle instance is tried again.
+func (n *ewmaNode[T, I]) load(now int64, tau float64) float64 {
+	n.mutex.Lock()
+	elapsed := float64(now - n.stamp)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	cost := n.cost * math.Exp(-elapsed/tau)
+	n.mutex.Unlock()
+	inflight := atomic.LoadInt64(&n.inflight)
+	if cost == 0 && inflight != 0 {
+		return penalty + float64(inflight)
+	}
+	return cost * float64(inflight+1)
+}
+
+// PeakEWMA is the latency-aware load-balance known from Finagle and Linkerd.
+// Every instance keeps a exponentially weighted moving average of its latency
+// that jumps to new peaks at once and decays with time,
+// `Select` samples two instances at random and returns
+// the one with the lower `estimate * (inflight+1)`.
+//
+// Callers feed the latency back through `Observe` and finish the request through `Done`,
+// or simply call the callback returned by `SelectWithDone`,
+// which measures the latency from the time the instance was selected.
+type PeakEWMA[T Hashable, I Instance[T]] struct {
+	mutex   sync.RWMutex
+	hashmap *haxmap.Map[T, *ewmaNode[T, I]]
+	nodes   []*ewmaNode[T, I]
+	random  XorShift64
+	// tau is the decay time in nanosecond
+	tau float64
+}
+
+// NewPeakEWMA returns a PeakEWMA load-balance,
+// the optional argument is the decay time of the latency estimate, 10 seconds by default.
+func NewPeakEWMA[T Hashable, I Instance[T]](decay ...time.Duration) *PeakEWMA[T, I] {
+	d := defaultDecayTime
+	if len(decay) != 0 && decay[0] > 0 {
+		d = decay[0]
+	}
+	return &PeakEWMA[T, I]{
+		hashmap: haxmap.New[T, *ewmaNode[T, I]](8),
+		nodes:   make([]*ewmaNode[T, I], 0, 8),
+		random:  NewXorShift64(uint64(time.Now().UnixNano())),
+		tau:     float64(d),
+	}
+}
+
+// Add some instances and return the number of successful operation
+func (pe *PeakEWMA[T, I]) Add(instances ...I) int {
+	pe.mutex.Lock()
+	defer pe.mutex.Unlock()
+	count := 0
+	now := time.Now().UnixNano()
+	for _, instance := range instances {
+		id := instance.InstanceID()
+		if _, ok := pe.hashmap.Get(id); !ok {
+			n := &ewmaNode[T, I]{
+				instance: instance,
+				cost:     float64(defaultRTT),
+				stamp:    now,
+			}
+			pe.hashmap.Set(id, n)
+			pe.nodes = append(pe.nodes, n)
+			count++
+		}
+	}
+	return count
+}
+
+// Del some instances and return the number of successful operation
+func (pe *PeakEWMA[T, I]) Del(instances ...I) int {
+	pe.mutex.Lock()
+	defer pe.mutex.Unlock()
+	count := 0
+	for _, instance := range instances {
+		id := instance.InstanceID()
+		if _, ok := pe.hashmap.Get(id); ok {
+			for i := 0; i < len(pe.nodes); i++ {
+				if pe.nodes[i].instance.InstanceID() == id {
+					pe.hashmap.Del(id)
+					pe.nodes = append(pe.nodes[:i], pe.nodes[i+1:]...)
+					break
+				}
+			}
+			count++
+		}
+	}
+	return count
+}
+
+// Replace the instances with the new set in one step
+// and return the number of instances added or deleted,
+// the instances kept are updated with their new value and keep their outstanding requests and latency estimate.
+func (pe *PeakEWMA[T, I]) Replace(instances ...I) int {
+	pe.mutex.Lock()
+	defer pe.mutex.Unlock()
+	now := time.Now().UnixNano()
+	var count int
+	pe.nodes, count = replaceNodes(pe.hashmap, pe.nodes, instances,
+		(*ewmaNode[T, I]).id,
+		func(instance I) *ewmaNode[T, I] {
+			return &ewmaNode[T, I]{
+				instance: instance,
+				cost:     float64(defaultRTT),
+				stamp:    now,
+			}
+		},
+		func(n *ewmaNode[T, I], instance I) *ewmaNode[T, I] {
+			n.instance = instance
+			return n
+		})
+	return count
+}
+
+// Get the value corresponding to the key
+func (pe *PeakEWMA[T, I]) Get(key T) (ins I, ok bool) {
+	pe.mutex.RLock()
+	defer pe.mutex.RUnlock()
+	if n, ok := haxMapGetVal(pe.hashmap, key); ok {
+		return n.instance, true
+	}
+	return
+}
+
+// ForEach every instances. it is concurrency safe,
+// the callback is called on a copy of the instances taken under the lock.
+func (pe *PeakEWMA[T, I]) ForEach(callback func(T, I) bool) {
+	pe.mutex.RLock()
+	instances := make([]I, len(pe.nodes))
+	for i, n := range pe.nodes {
+		instances[i] = n.instance
+	}
+	pe.mutex.RUnlock()
+	for _, instance := range instances {
+		if !callback(instance.InstanceID(), instance) {
+			return
+		}
+	}
+}
+
+func (pe *PeakEWMA[T, I]) Size() int {
+	return int(pe.hashmap.Len())
+}
+
+// Estimate returns the current latency estimate of the instance.
+func (pe *PeakEWMA[T, I]) Estimate(key T) time.Duration {
+	n, ok := pe.hashmap.Get(key)
+	if !ok {
+		return 0
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	elapsed := float64(time.Now().UnixNano() - n.stamp)
+	return time.Duration(n.cost * math.Exp(-elapsed/pe.tau))
+}
+
+// Select a instance and count a new outstanding request on it
+func (pe *PeakEWMA[T, I]) Select() (ins I) {
+	ins, _ = pe.selectNode(nil)
+	return
+}
+
+// Pick selects a instance like `Select`, or returns `ErrNoInstances` if there is none
+func (pe *PeakEWMA[T, I]) Pick(ctx context.Context) (I, error) {
+	return pick(ctx, func() (I, bool) { return pe.selectExcluding(nil) }, nil)
+}
+
+// SelectN selects at most n distinct instances like `Select`,
+// every one of them must be passed to `Done`
+func (pe *PeakEWMA[T, I]) SelectN(n int) []I {
+	return selectN[T, I](pe, n)
+}
+
+// SelectExcluding selects a instance like `Select` other than the excluded ones
+func (pe *PeakEWMA[T, I]) SelectExcluding(exclude ...T) I {
+	ins, _ := pe.selectExcluding(exclude)
+	return ins
+}
+
+func (pe *PeakEWMA[T, I]) selectExcluding(exclude []T) (ins I, ok bool) {
+	ins, n := pe.selectNode(exclude)
+	return ins, n != nil
+}
+
+// SelectWithDone selects a instance like `Select` and returns a callback
+// that finishes the request on it and observes the time elapsed since the selection as its latency.
+// The callback is safe to be called more than once.
+func (pe *PeakEWMA[T, I]) SelectWithDone() (ins I, done func()) {
+	ins, n := pe.selectNode(nil)
+	if n == nil {
+		return ins, func() {}
+	}
+	start := time.Now().UnixNano()
+	return ins, onceDone(func() {
+		now := time.Now().UnixNano()
+		n.observe(float64(now-start), now, pe.tau)
+		decrementInflight(&n.inflight)
+	})
+}
+
+// Observe feeds a latency sample of the instance into its estimate
+func (pe *PeakEWMA[T, I]) Observe(ins I, rtt time.Duration) {
+	if n, ok := pe.hashmap.Get(ins.InstanceID()); ok {
+		n.observe(float64(rtt), time.Now().UnixNano(), pe.tau)
+	}
+}
+
+// Done reports that a request sent to the instance has finished
+func (pe *PeakEWMA[T, I]) Done(ins I) {
+	if n, ok := pe.hashmap.Get(ins.InstanceID()); ok {
+		decrementInflight(&n.inflight)
+	}
+}
+
+// selectNode returns the instance of the node as well,
+// it is read under the lock since `Replace` updates the kept instances.
+func (pe *PeakEWMA[T, I]) selectNode(exclude []T) (ins I, n *ewmaNode[T, I]) {
+	pe.mutex.RLock()
+	defer pe.mutex.RUnlock()
+	nodes := pe.nodes
+	if len(exclude) != 0 {
+		nodes = excludeNodes(nodes, exclude)
+	}
+	size := uint64(len(nodes))
+	if size == 0 {
+		return
+	}
+	n = nodes[0]
+	if size > 1 {
+		i := pe.random.Uint64() % size
+		j := pe.random.Uint64() % (size - 1)
+		if j >= i {
+			j++
+		}
+		a, b := nodes[i], nodes[j]
+		now := time.Now().UnixNano()
+		if b.load(now, pe.tau) < a.load(now, pe.tau) {
+			n = b
+		} else {
+			n = a
+		}
+	}
+	atomic.AddInt64(&n.inflight, 1)
+	return n.instance, n
+}
+
+func (n *ewmaNode[T, I]) id() T {
+	return n.instance.InstanceID()
+}
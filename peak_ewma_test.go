@@ -0,0 +1,114 @@
+package loadbalance_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ydmxcz/loadbalance"
+)
+
+func TestPeakEWMAPrefersFastInstance(t *testing.T) {
+	lb := loadbalance.NewPeakEWMA[string, *myService](time.Minute)
+	ins := getInstance(1)
+	fast, slow := ins[0], ins[1]
+	lb.Add(fast, slow)
+
+	lb.Observe(slow, time.Second)
+	if got := lb.Estimate(slow.InstanceID()); got < 900*time.Millisecond {
+		t.Fatalf("peak was not taken at once, estimate is %v", got)
+	}
+	lb.Observe(fast, time.Millisecond)
+
+	for i := 0; i < 100; i++ {
+		ins := lb.Select()
+		lb.Done(ins)
+		if ins != fast {
+			t.Fatalf("selected %s with estimate %v", ins.Address, lb.Estimate(ins.InstanceID()))
+		}
+	}
+}
+
+func TestPeakEWMASelectWithDone(t *testing.T) {
+	lb := loadbalance.NewPeakEWMA[string, *myService]()
+	if _, done := lb.SelectWithDone(); done == nil {
+		t.Fatal("done callback of an empty balancer is nil")
+	}
+	ins := getInstance(1)[0]
+	lb.Add(ins)
+	sel, done := lb.SelectWithDone()
+	if sel != ins {
+		t.Fatal("selected a unknown instance")
+	}
+	done()
+	if got := lb.Estimate(ins.InstanceID()); got >= 30*time.Millisecond {
+		t.Fatalf("estimate did not decay towards the observed latency, got %v", got)
+	}
+}
+
+func TestPeakEWMASelectExcluding(t *testing.T) {
+	lb := loadbalance.NewPeakEWMA[string, *myService](time.Minute)
+	ins := getInstance(1)
+	lb.Add(ins...)
+	lb.Observe(ins[0], time.Millisecond)
+	lb.Observe(ins[1], 10*time.Millisecond)
+	lb.Observe(ins[2], time.Second)
+
+	// the fastest is excluded, the next fastest is selected
+	for i := 0; i < 100; i++ {
+		sel := lb.SelectExcluding(ins[0].Address)
+		if sel != ins[1] {
+			t.Fatalf("selected %v with estimate %v", sel, lb.Estimate(sel.InstanceID()))
+		}
+		lb.Done(sel)
+	}
+	if sel := lb.SelectExcluding(ins[0].Address, ins[1].Address, ins[2].Address); sel != nil {
+		t.Fatalf("got %s when all are excluded", sel.Address)
+	}
+}
+
+func TestPeakEWMASelectN(t *testing.T) {
+	lb := loadbalance.NewPeakEWMA[string, *myService](time.Minute)
+	ins := getInstance(1)
+	lb.Add(ins...)
+	lb.Observe(ins[0], time.Second)
+	lb.Observe(ins[1], time.Millisecond)
+	lb.Observe(ins[2], 10*time.Millisecond)
+
+	// the slowest loses every pair it is sampled in
+	for i := 0; i < 100; i++ {
+		res := lb.SelectN(2)
+		checkDistinct(t, res, 2)
+		for _, in := range res {
+			if in == ins[0] {
+				t.Fatalf("selected the slowest %s", ins[0].Address)
+			}
+			lb.Done(in)
+		}
+	}
+}
+
+func TestPeakEWMAReplace(t *testing.T) {
+	lb := loadbalance.NewPeakEWMA[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins[0], ins[1])
+	lb.Observe(ins[1], time.Second)
+	updated := &myService{Address: ins[1].Address, Memory: 7}
+	if n := lb.Replace(updated, ins[2]); n != 2 || lb.Size() != 2 {
+		t.Fatalf("replaced %d instances, %d left", n, lb.Size())
+	}
+	// the kept instance is the new value and keeps its latency estimate
+	if got, ok := lb.Get(ins[1].Address); !ok || got != updated {
+		t.Fatalf("got %v, want the new value of the kept instance", got)
+	}
+	if e := lb.Estimate(ins[1].Address); e < 500*time.Millisecond {
+		t.Fatalf("the estimate of the kept instance is %v, want about 1s", e)
+	}
+	for i := 0; i < 100; i++ {
+		sel := lb.Select()
+		if sel != ins[2] {
+			t.Fatalf("selected %v, want the new instance faster than the kept one", sel)
+		}
+		lb.Done(sel)
+	}
+	checkReplaceAtomic(t, loadbalance.NewPeakEWMA[string, *myService]())
+}
@@ -1,6 +1,7 @@
 package loadbalance
 
 import (
+	"context"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -44,22 +45,47 @@ func (xs *XorShift64) Int31() int32 {
 	return int32(int64(xs.Uint64()&rngMask) >> 32)
 }
 
+// randomPool hands out a XorShift64 per P by `sync.Pool`,
+// so the goroutines running in parallel never share the state of a generator.
+type randomPool struct {
+	pool sync.Pool
+	seed uint64
+}
+
+func newRandomPool() *randomPool {
+	rp := &randomPool{seed: uint64(time.Now().UnixNano())}
+	rp.pool.New = func() any {
+		// a state of xorshift must not be zero
+		xs := NewXorShift64(qwordHasher(atomic.AddUint64(&rp.seed, 0x9e3779b97f4a7c15)) | 1)
+		return &xs
+	}
+	return rp
+}
+
+func (rp *randomPool) Uint64() uint64 {
+	xs := rp.pool.Get().(*XorShift64)
+	v := xs.Uint64()
+	rp.pool.Put(xs)
+	return v
+}
+
 func NewRandom[T Hashable, I Instance[T]]() *Random[T, I] {
-	return &Random[T, I]{
-		instances:    make([]I, 0, 8),
+	rb := &Random[T, I]{
 		instancesMap: haxmap.New[T, I](8),
-		random: XorShift64{
-			state: uint64(time.Now().UnixNano()),
-		},
+		random:       newRandomPool(),
 	}
+	rb.instances.Store(&[]I{})
+	return rb
 }
 
 // Random 随机负载均衡
+// Writers copy the slice of instances under the mutex and publish the new one atomically (copy-on-write),
+// `Select` only loads the current slice and draws from the generator of its own P, it takes no lock.
 type Random[T Hashable, I Instance[T]] struct {
 	mutex        sync.Mutex
 	instancesMap *haxmap.Map[T, I] //map[T]Instance[T]
-	instances    []I
-	random       XorShift64
+	instances    atomic.Pointer[[]I]
+	random       *randomPool
 }
 
 func (rb *Random[T, I]) Get(key T) (I, bool) {
@@ -73,24 +99,84 @@ func (rb *Random[T, I]) ForEach(callback func(T, I) bool) {
 func (rb *Random[T, I]) Add(instances ...I) int {
 	rb.mutex.Lock()
 	defer rb.mutex.Unlock()
+	old := *rb.instances.Load()
+	next := make([]I, len(old), len(old)+len(instances))
+	copy(next, old)
 	count := 0
 	for _, instance := range instances {
 		if _, ok := rb.instancesMap.Get(instance.InstanceID()); !ok {
 			rb.instancesMap.Set(instance.InstanceID(), instance)
-			rb.instances = append(rb.instances, instance)
+			next = append(next, instance)
 			count++
 		}
 	}
+	if count > 0 {
+		rb.instances.Store(&next)
+	}
 	return count
 }
 
-func (rb *Random[T, I]) Select() (ins I) {
+// Replace the instances with the new set in one step
+// and return the number of instances added or deleted
+func (rb *Random[T, I]) Replace(instances ...I) int {
 	rb.mutex.Lock()
 	defer rb.mutex.Unlock()
-	if len(rb.instances) == 0 {
+	next, count := replaceInstances(rb.instancesMap, *rb.instances.Load(), instances)
+	rb.instances.Store(&next)
+	return count
+}
+
+func (rb *Random[T, I]) Select() (ins I) {
+	instances := *rb.instances.Load()
+	if len(instances) == 0 {
+		return
+	}
+	return instances[rb.random.Uint64()%uint64(len(instances))]
+}
+
+// Pick selects a instance like `Select`, or returns `ErrNoInstances` if there is none
+func (rb *Random[T, I]) Pick(ctx context.Context) (I, error) {
+	return pick(ctx, func() (I, bool) { return rb.selectExcluding(nil) }, nil)
+}
+
+// SelectN selects at most n distinct instances at random
+func (rb *Random[T, I]) SelectN(n int) []I {
+	return selectN[T, I](rb, n)
+}
+
+// SelectExcluding selects a instance at random other than the excluded ones
+func (rb *Random[T, I]) SelectExcluding(exclude ...T) I {
+	ins, _ := rb.selectExcluding(exclude)
+	return ins
+}
+
+func (rb *Random[T, I]) selectExcluding(exclude []T) (ins I, ok bool) {
+	return randomExcluding(*rb.instances.Load(), rb.random, exclude)
+}
+
+// randomExcluding selects a instance uniformly at random other than the excluded ones.
+// It draws again a few times when hitting a excluded instance,
+// then walks from a random position so that it always terminates.
+func randomExcluding[T Hashable, I Instance[T]](instances []I, random interface{ Uint64() uint64 },
+	exclude []T) (ins I, ok bool) {
+	n := uint64(len(instances))
+	if n == 0 {
 		return
 	}
-	return rb.instances[int(rb.random.Uint64()%uint64(len(rb.instances)))]
+	for i := 0; i <= len(exclude); i++ {
+		ins = instances[random.Uint64()%n]
+		if !excluded(exclude, ins.InstanceID()) {
+			return ins, true
+		}
+	}
+	start := random.Uint64() % n
+	for i := uint64(0); i < n; i++ {
+		ins = instances[(start+i)%n]
+		if !excluded(exclude, ins.InstanceID()) {
+			return ins, true
+		}
+	}
+	return *new(I), false
 }
 
 func (rb *Random[T, I]) Size() int {
@@ -100,14 +186,15 @@ func (rb *Random[T, I]) Size() int {
 func (rb *Random[T, I]) Del(instances ...I) int {
 	rb.mutex.Lock()
 	defer rb.mutex.Unlock()
+	next := append([]I(nil), *rb.instances.Load()...)
 	count := 0
 	for _, instance := range instances {
 		id := instance.InstanceID()
 		if _, ok := rb.instancesMap.Get(id); ok {
-			for i := 0; i < len(rb.instances); i++ {
-				if rb.instances[i].InstanceID() == id {
+			for i := 0; i < len(next); i++ {
+				if next[i].InstanceID() == id {
 					rb.instancesMap.Del(id)
-					rb.instances = append(rb.instances[:i], rb.instances[i+1:]...)
+					next = append(next[:i], next[i+1:]...)
 					break
 				}
 			}
@@ -115,6 +202,9 @@ func (rb *Random[T, I]) Del(instances ...I) int {
 			count++
 		}
 	}
+	if count > 0 {
+		rb.instances.Store(&next)
+	}
 	return count
 
 }
@@ -50,3 +50,63 @@ func BenchmarkRandSourceParallel(b *testing.B) {
 		}
 	})
 }
+
+func TestRandomSelectExcluding(t *testing.T) {
+	lb := loadbalance.NewRandom[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins...)
+
+	// the rest are selected uniformly
+	m := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		m[lb.SelectExcluding(ins[0].Address).Address]++
+	}
+	if m[ins[0].Address] != 0 || m[ins[1].Address] < 4500 || m[ins[2].Address] < 4500 {
+		t.Fatalf("got %v when %s is excluded", m, ins[0].Address)
+	}
+	if sel := lb.SelectExcluding(ins[0].Address, ins[1].Address, ins[2].Address); sel != nil {
+		t.Fatalf("got %s when all are excluded", sel.Address)
+	}
+}
+
+func TestRandomSelectN(t *testing.T) {
+	lb := loadbalance.NewRandom[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins...)
+
+	// every instance is left out by a third of the calls
+	m := map[string]int{}
+	for i := 0; i < 3000; i++ {
+		res := lb.SelectN(2)
+		checkDistinct(t, res, 2)
+		for _, in := range res {
+			m[in.Address]++
+		}
+	}
+	for _, in := range ins {
+		if m[in.Address] < 1800 || m[in.Address] > 2200 {
+			t.Fatalf("got %v in 3000 calls of SelectN(2)", m)
+		}
+	}
+	checkDistinct(t, lb.SelectN(5), len(ins))
+}
+
+func TestRandomReplace(t *testing.T) {
+	lb := loadbalance.NewRandom[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins[0], ins[1])
+	if n := lb.Replace(ins[1], ins[2], ins[2]); n != 2 || lb.Size() != 2 {
+		t.Fatalf("replaced %d instances, %d left", n, lb.Size())
+	}
+	m := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		m[lb.Select().Address]++
+	}
+	if m[ins[0].Address] != 0 || m[ins[1].Address] < 4500 || m[ins[2].Address] < 4500 {
+		t.Fatalf("got %v after replace", m)
+	}
+	if n := lb.Replace(); n != 2 || lb.Select() != nil {
+		t.Fatalf("replaced %d instances with nothing", n)
+	}
+	checkReplaceAtomic(t, loadbalance.NewRandom[string, *myService]())
+}
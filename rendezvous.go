@@ -0,0 +1,210 @@
+package loadbalance
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/alphadose/haxmap"
+)
+
+// `hrwNode` is a instance with the hash of its id
+// and the weight it was added with.
+type hrwNode[T Hashable, I Instance[T]] struct {
+	instance I
+	hash     uint64
+	weight   float64
+}
+
+// score is the logarithmic weighted score `-weight / ln(u)`
+// where `u` in (0,1) is derived from the hash of key and instance.
+func (n *hrwNode[T, I]) score(keyHash uint64) float64 {
+	h := qwordHasher(keyHash ^ n.hash)
+	// the 53 high bits as a float in (0,1)
+	u := (float64(h>>11) + 0.5) / (1 << 53)
+	return -n.weight / math.Log(u)
+}
+
+// Rendezvous is the highest random weight hashing.
+// Every instance is scored with the hash of (key, InstanceID())
+// and the key goes to the instance with the highest score.
+// Adding or deleting a instance only moves the keys that go to it,
+// there is no replica to tune as in `ConsistentHash`,
+// but select costs O(n) of the number of instance.
+//
+// Weights are supported by the logarithmic method,
+// the share of keys of a instance is proportional to its weight read when it is added.
+type Rendezvous[T Hashable, I Instance[T]] struct {
+	rwmutex  sync.RWMutex
+	hashmap  *haxmap.Map[T, *hrwNode[T, I]]
+	nodes    []*hrwNode[T, I]
+	hashfunc strHashFunc
+	idhash   func(T) uint64
+}
+
+func NewRendezvous[T Hashable, I Instance[T]]() *Rendezvous[T, I] {
+	return &Rendezvous[T, I]{
+		hashmap:  haxmap.New[T, *hrwNode[T, I]](8),
+		nodes:    make([]*hrwNode[T, I], 0, 8),
+		hashfunc: GetHashFunc[string](),
+		idhash:   GetHashFunc[T](),
+	}
+}
+
+// Add some instances and return the number of successful operation
+func (rv *Rendezvous[T, I]) Add(instances ...I) int {
+	rv.rwmutex.Lock()
+	defer rv.rwmutex.Unlock()
+	count := 0
+	for _, instance := range instances {
+		id := instance.InstanceID()
+		if _, ok := rv.hashmap.Get(id); !ok {
+			n := &hrwNode[T, I]{
+				instance: instance,
+				hash:     rv.idhash(id),
+				weight:   float64(instanceWeight[T](instance)),
+			}
+			rv.hashmap.Set(id, n)
+			rv.nodes = append(rv.nodes, n)
+			count++
+		}
+	}
+	return count
+}
+
+// Del some instances and return the number of successful operation
+func (rv *Rendezvous[T, I]) Del(instances ...I) int {
+	rv.rwmutex.Lock()
+	defer rv.rwmutex.Unlock()
+	count := 0
+	for _, instance := range instances {
+		id := instance.InstanceID()
+		if _, ok := rv.hashmap.Get(id); ok {
+			for i := 0; i < len(rv.nodes); i++ {
+				if rv.nodes[i].instance.InstanceID() == id {
+					rv.hashmap.Del(id)
+					rv.nodes = append(rv.nodes[:i], rv.nodes[i+1:]...)
+					break
+				}
+			}
+			count++
+		}
+	}
+	return count
+}
+
+// Replace the instances with the new set in one step
+// and return the number of instances added or deleted,
+// the instances kept are updated with their new weight.
+func (rv *Rendezvous[T, I]) Replace(instances ...I) int {
+	rv.rwmutex.Lock()
+	defer rv.rwmutex.Unlock()
+	newNode := func(instance I) *hrwNode[T, I] {
+		return &hrwNode[T, I]{
+			instance: instance,
+			hash:     rv.idhash(instance.InstanceID()),
+			weight:   float64(instanceWeight[T](instance)),
+		}
+	}
+	var count int
+	rv.nodes, count = replaceNodes(rv.hashmap, rv.nodes, instances,
+		func(n *hrwNode[T, I]) T { return n.instance.InstanceID() },
+		newNode,
+		func(_ *hrwNode[T, I], instance I) *hrwNode[T, I] { return newNode(instance) })
+	return count
+}
+
+// Get the value corresponding to the key
+func (rv *Rendezvous[T, I]) Get(key T) (ins I, ok bool) {
+	if n, ok := haxMapGetVal(rv.hashmap, key); ok {
+		return n.instance, true
+	}
+	return
+}
+
+// ForEach every instances. it is concurrency safe.
+func (rv *Rendezvous[T, I]) ForEach(callback func(T, I) bool) {
+	haxMapForEach(rv.hashmap, func(key T, n *hrwNode[T, I]) bool {
+		return callback(key, n.instance)
+	})
+}
+
+func (rv *Rendezvous[T, I]) Size() int {
+	return int(rv.hashmap.Len())
+}
+
+// SelectBy returns the instance with the highest score for the key
+func (rv *Rendezvous[T, I]) SelectBy(key string) (ins I) {
+	kh := rv.hashfunc(key)
+	rv.rwmutex.RLock()
+	defer rv.rwmutex.RUnlock()
+	best := math.Inf(-1)
+	for _, n := range rv.nodes {
+		if s := n.score(kh); s > best {
+			best = s
+			ins = n.instance
+		}
+	}
+	return
+}
+
+// PickBy selects a instance for the key like `SelectBy`, or returns `ErrNoInstances` if there is none
+func (rv *Rendezvous[T, I]) PickBy(ctx context.Context, key string) (I, error) {
+	return pick(ctx, func() (I, bool) { return rv.selectByExcluding(key, nil) }, nil)
+}
+
+// SelectByExcluding returns the instance with the highest score for the key other than the excluded ones
+func (rv *Rendezvous[T, I]) SelectByExcluding(key string, exclude ...T) I {
+	ins, _ := rv.selectByExcluding(key, exclude)
+	return ins
+}
+
+func (rv *Rendezvous[T, I]) selectByExcluding(key string, exclude []T) (ins I, ok bool) {
+	kh := rv.hashfunc(key)
+	rv.rwmutex.RLock()
+	defer rv.rwmutex.RUnlock()
+	best := math.Inf(-1)
+	for _, n := range rv.nodes {
+		if excluded(exclude, n.instance.InstanceID()) {
+			continue
+		}
+		if s := n.score(kh); s > best {
+			best = s
+			ins, ok = n.instance, true
+		}
+	}
+	return
+}
+
+// SelectNBy returns at most n distinct instances for the key ordered by score,
+// the first one is the instance `SelectBy` returns.
+// It is the placement of n replicas of the key.
+func (rv *Rendezvous[T, I]) SelectNBy(key string, n int) []I {
+	if n <= 0 {
+		return nil
+	}
+	kh := rv.hashfunc(key)
+	rv.rwmutex.RLock()
+	type scored struct {
+		node  *hrwNode[T, I]
+		score float64
+	}
+	all := make([]scored, len(rv.nodes))
+	for i, node := range rv.nodes {
+		all[i] = scored{node: node, score: node.score(kh)}
+	}
+	rv.rwmutex.RUnlock()
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].score > all[j].score
+	})
+	if n > len(all) {
+		n = len(all)
+	}
+	res := make([]I, n)
+	for i := 0; i < n; i++ {
+		res[i] = all[i].node.instance
+	}
+	return res
+}
@@ -0,0 +1,119 @@
+package loadbalance_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ydmxcz/loadbalance"
+)
+
+func TestRendezvousWeightedShare(t *testing.T) {
+	var lb loadbalance.SelectorBy[string, *myService] = loadbalance.NewRendezvous[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins...)
+
+	m := map[*myService]int{}
+	for i := 0; i < 10000; i++ {
+		m[lb.SelectBy(fmt.Sprintf("key-%d", i))]++
+	}
+	for _, in := range ins {
+		want := in.Memory * 1000
+		if got := m[in]; got < want*9/10 || got > want*11/10 {
+			t.Fatalf("%s got %d keys, want about %d", in.Address, got, want)
+		}
+	}
+}
+
+func TestRendezvousMinimalDisruption(t *testing.T) {
+	lb := loadbalance.NewRendezvous[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins...)
+
+	before := map[string]*myService{}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		before[key] = lb.SelectBy(key)
+	}
+	lb.Del(ins[1])
+	for key, old := range before {
+		got := lb.SelectBy(key)
+		if old != ins[1] && got != old {
+			t.Fatalf("key %s moved from %s to %s", key, old.Address, got.Address)
+		}
+	}
+}
+
+func TestRendezvousSelectNBy(t *testing.T) {
+	lb := loadbalance.NewRendezvous[string, *myService]()
+	lb.Add(getInstance(1)...)
+
+	if got := lb.SelectNBy("user:42", 5); len(got) != 3 {
+		t.Fatalf("got %d instances, want 3", len(got))
+	}
+	got := lb.SelectNBy("user:42", 2)
+	if len(got) != 2 || got[0] == got[1] {
+		t.Fatal("replicas are not distinct")
+	}
+	if got[0] != lb.SelectBy("user:42") {
+		t.Fatal("first replica is not the instance SelectBy returns")
+	}
+}
+
+// A retry excluding the instance of the key lands where the key would go without that instance
+func TestRendezvousSelectByExcluding(t *testing.T) {
+	ins := getInstance(1)
+	lb := loadbalance.NewRendezvous[string, *myService]()
+	lb.Add(ins...)
+	for _, gone := range ins {
+		rest := loadbalance.NewRendezvous[string, *myService]()
+		for _, in := range ins {
+			if in != gone {
+				rest.Add(in)
+			}
+		}
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("key-%d", i)
+			if sel, want := lb.SelectByExcluding(key, gone.Address), rest.SelectBy(key); sel != want {
+				t.Fatalf("key %s went to %v without %s, want %v", key, sel, gone.Address, want)
+			}
+		}
+	}
+	if sel := lb.SelectByExcluding("key", ins[0].Address, ins[1].Address, ins[2].Address); sel != nil {
+		t.Fatalf("got %s when all are excluded", sel.Address)
+	}
+}
+
+func TestRendezvousPickBy(t *testing.T) {
+	lb := loadbalance.NewRendezvous[string, *myService]()
+	if _, err := lb.PickBy(context.Background(), "key"); !errors.Is(err, loadbalance.ErrNoInstances) {
+		t.Fatalf("got %v, want ErrNoInstances", err)
+	}
+	lb.Add(getInstance(1)...)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if sel, err := lb.PickBy(context.Background(), key); err != nil || sel != lb.SelectBy(key) {
+			t.Fatalf("picked %v, %v for key %s, want %s", sel, err, key, lb.SelectBy(key).Address)
+		}
+	}
+}
+
+func TestRendezvousReplace(t *testing.T) {
+	lb := loadbalance.NewRendezvous[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins...)
+	updated := &myService{Address: ins[0].Address, Memory: 1}
+	if n := lb.Replace(updated, ins[2]); n != 1 || lb.Size() != 2 {
+		t.Fatalf("replaced %d instances, %d left", n, lb.Size())
+	}
+	// the keys go where they go on a Rendezvous of the new set with the new weights
+	want := loadbalance.NewRendezvous[string, *myService]()
+	want.Add(updated, ins[2])
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if sel := lb.SelectBy(key); sel != want.SelectBy(key) {
+			t.Fatalf("key %s went to %v after replace", key, sel)
+		}
+	}
+}
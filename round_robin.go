@@ -1,40 +1,52 @@
 package loadbalance
 
 import (
+	"context"
+	"math"
 	"sync"
+	"sync/atomic"
 
 	"github.com/alphadose/haxmap"
 )
 
 // 轮询负载均衡
+// Writers copy the slice of instances under the mutex and publish the new one atomically (copy-on-write),
+// `Select` only loads the current slice and takes the next index from an atomic counter, it takes no lock.
 type RoundRobin[T Hashable, I Instance[T]] struct {
-	curIndex     int
+	curIndex     uint64
 	mutex        sync.Mutex
 	instancesMap *haxmap.Map[T, I] //map[T]I
-	instances    []I
+	instances    atomic.Pointer[[]I]
 }
 
 func NewRoundRobin[T Hashable, I Instance[T]]() *RoundRobin[T, I] {
-	return &RoundRobin[T, I]{
+	rr := &RoundRobin[T, I]{
 		instancesMap: haxmap.New[T, I](8),
-		instances:    make([]I, 0, 8),
 	}
+	rr.instances.Store(&[]I{})
+	return rr
 }
 
 func (rr *RoundRobin[T, I]) Add(instances ...I) int {
 	rr.mutex.Lock()
 	defer rr.mutex.Unlock()
 
+	old := *rr.instances.Load()
+	next := make([]I, len(old), len(old)+len(instances))
+	copy(next, old)
 	count := 0
 	for _, instance := range instances {
 		if _, ok := rr.instancesMap.Get(instance.InstanceID()); !ok {
 			rr.instancesMap.Set(instance.InstanceID(), instance)
 
-			rr.instances = append(rr.instances, instance)
+			next = append(next, instance)
 			count++
 		}
 
 	}
+	if count > 0 {
+		rr.instances.Store(&next)
+	}
 	return count
 }
 
@@ -55,35 +67,83 @@ func (rr *RoundRobin[T, I]) ForEach(callback func(T, I) bool) {
 }
 
 func (rr *RoundRobin[T, I]) Select() (ins I) {
-	if rr.instancesMap.Len() == 0 {
+	instances := *rr.instances.Load()
+	if len(instances) == 0 {
 		return ins
 	}
+	c := atomic.AddUint64(&rr.curIndex, 1) % uint64(len(instances))
+	return instances[c]
+}
 
-	rr.mutex.Lock()
-	defer rr.mutex.Unlock()
+// Pick selects a instance like `Select`, or returns `ErrNoInstances` if there is none
+func (rr *RoundRobin[T, I]) Pick(ctx context.Context) (I, error) {
+	return pick(ctx, func() (I, bool) { return rr.selectExcluding(nil) }, nil)
+}
 
-	c := (rr.curIndex + 1) % len(rr.instances)
-	rr.curIndex = c
-	return rr.instances[c]
+// SelectN selects the next n distinct instances
+func (rr *RoundRobin[T, I]) SelectN(n int) []I {
+	return selectN[T, I](rr, n)
+}
+
+// SelectExcluding selects the next instance other than the excluded ones
+func (rr *RoundRobin[T, I]) SelectExcluding(exclude ...T) I {
+	ins, _ := rr.selectExcluding(exclude)
+	return ins
+}
+
+func (rr *RoundRobin[T, I]) selectExcluding(exclude []T) (ins I, ok bool) {
+	instances := *rr.instances.Load()
+	n := uint64(len(instances))
+	if n == 0 {
+		return
+	}
+	start := atomic.AddUint64(&rr.curIndex, 1)
+	for i := uint64(0); i < n; i++ {
+		if ins = instances[(start+i)%n]; !excluded(exclude, ins.InstanceID()) {
+			// the skipped instances count as passed
+			if i > 0 {
+				atomic.AddUint64(&rr.curIndex, i)
+			}
+			return ins, true
+		}
+	}
+	return *new(I), false
 }
 
 func (rr *RoundRobin[T, I]) Del(instances ...I) int {
 	rr.mutex.Lock()
 	defer rr.mutex.Unlock()
+	next := append([]I(nil), *rr.instances.Load()...)
 	count := 0
 	for _, instance := range instances {
 		id := instance.InstanceID()
 		if _, ok := rr.instancesMap.Get(id); ok {
-			for i := 0; i < len(rr.instances); i++ {
-				if rr.instances[i].InstanceID() == id {
+			for i := 0; i < len(next); i++ {
+				if next[i].InstanceID() == id {
 					rr.instancesMap.Del(instance.InstanceID())
-					rr.instances = append(rr.instances[:i], rr.instances[i+1:]...)
+					next = append(next[:i], next[i+1:]...)
 					break
 				}
 			}
 			count++
 		}
 	}
+	if count > 0 {
+		rr.instances.Store(&next)
+	}
+	return count
+}
+
+// Replace the instances with the new set in one step
+// and return the number of instances added or deleted,
+// the next instance is the first one of the new set.
+func (rr *RoundRobin[T, I]) Replace(instances ...I) int {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+	next, count := replaceInstances(rr.instancesMap, *rr.instances.Load(), instances)
+	// the counter wraps to the first instance
+	atomic.StoreUint64(&rr.curIndex, math.MaxUint64)
+	rr.instances.Store(&next)
 	return count
 }
 
@@ -0,0 +1,170 @@
+package loadbalance_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/ydmxcz/loadbalance"
+)
+
+func TestRoundRobinEven(t *testing.T) {
+	rr := loadbalance.NewRoundRobin[string, *myService]()
+	ins := getInstance(1)
+	rr.Add(ins...)
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	counts := make(map[string]int)
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			local := make(map[string]int)
+			for i := 0; i < 3000; i++ {
+				local[rr.Select().Address]++
+			}
+			mutex.Lock()
+			for k, v := range local {
+				counts[k] += v
+			}
+			mutex.Unlock()
+		}()
+	}
+	wg.Wait()
+	// the atomic counter hands out every index exactly once per cycle
+	for _, in := range ins {
+		if counts[in.Address] != 4000 {
+			t.Fatalf("%s was selected %d times, want 4000: %v", in.Address, counts[in.Address], counts)
+		}
+	}
+}
+
+// The selectors read a snapshot of the instances without lock while writers publish new ones.
+func TestCopyOnWriteSelect(t *testing.T) {
+	selectors := map[string]loadbalance.Selector[string, *myService]{
+		"Random":     loadbalance.NewRandom[string, *myService](),
+		"RoundRobin": loadbalance.NewRoundRobin[string, *myService](),
+	}
+	for name, s := range selectors {
+		t.Run(name, func(t *testing.T) {
+			fixed := &myService{Address: "fixed", Memory: 1}
+			s.Add(fixed)
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer close(stop)
+				for i := 0; i < 500; i++ {
+					in := &myService{Address: fmt.Sprintf("10.0.0.%d:80", i%16), Memory: 1}
+					s.Add(in)
+					s.Del(in)
+				}
+			}()
+			for {
+				select {
+				case <-stop:
+					wg.Wait()
+					if s.Size() != 1 {
+						t.Fatalf("%d instances left, want 1", s.Size())
+					}
+					return
+				default:
+				}
+				if s.Select() == nil {
+					t.Fatal("selected nothing while the fixed instance is there")
+				}
+			}
+		})
+	}
+}
+
+func TestRoundRobinSelectExcluding(t *testing.T) {
+	lb := loadbalance.NewRoundRobin[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins...)
+
+	// the excluded instance is skipped and the rest take turns
+	last := lb.SelectExcluding(ins[1].Address)
+	for i := 0; i < 100; i++ {
+		sel := lb.SelectExcluding(ins[1].Address)
+		if sel == ins[1] || sel == last {
+			t.Fatalf("selected %s after %s when %s is excluded", sel.Address, last.Address, ins[1].Address)
+		}
+		last = sel
+	}
+	if sel := lb.SelectExcluding(ins[0].Address, ins[1].Address, ins[2].Address); sel != nil {
+		t.Fatalf("got %s when all are excluded", sel.Address)
+	}
+}
+
+func TestRoundRobinSelectN(t *testing.T) {
+	lb := loadbalance.NewRoundRobin[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins...)
+
+	// the calls go on taking turns, so 3 calls of SelectN(2) select every instance twice
+	m := map[string]int{}
+	for i := 0; i < 3; i++ {
+		res := lb.SelectN(2)
+		checkDistinct(t, res, 2)
+		for _, in := range res {
+			m[in.Address]++
+		}
+	}
+	for _, in := range ins {
+		if m[in.Address] != 2 {
+			t.Fatalf("got %v in 3 calls of SelectN(2)", m)
+		}
+	}
+	checkDistinct(t, lb.SelectN(5), len(ins))
+}
+
+func TestRoundRobinPick(t *testing.T) {
+	lb := loadbalance.NewRoundRobin[string, *myService]()
+	if _, err := lb.Pick(context.Background()); !errors.Is(err, loadbalance.ErrNoInstances) {
+		t.Fatalf("got %v, want ErrNoInstances", err)
+	}
+	ins := getInstance(1)
+	lb.Add(ins...)
+
+	// Pick takes turns with `Select`
+	last := lb.Select()
+	for i := 0; i < 10; i++ {
+		sel, err := lb.Pick(context.Background())
+		if err != nil || sel == last {
+			t.Fatalf("picked %v, %v after %s", sel, err, last.Address)
+		}
+		last = sel
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := lb.Pick(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	lb.Del(ins...)
+	if _, err := lb.Pick(context.Background()); !errors.Is(err, loadbalance.ErrNoInstances) {
+		t.Fatalf("got %v after delete, want ErrNoInstances", err)
+	}
+}
+
+func TestRoundRobinReplace(t *testing.T) {
+	lb := loadbalance.NewRoundRobin[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins[0], ins[1])
+	if n := lb.Replace(ins[1], ins[2]); n != 2 || lb.Size() != 2 {
+		t.Fatalf("replaced %d instances, %d left", n, lb.Size())
+	}
+	// the new set takes turns
+	m := map[string]int{}
+	for i := 0; i < 100; i++ {
+		m[lb.Select().Address]++
+	}
+	if m[ins[1].Address] != 50 || m[ins[2].Address] != 50 {
+		t.Fatalf("got %v after replace", m)
+	}
+	checkReplaceAtomic(t, loadbalance.NewRoundRobin[string, *myService]())
+}
@@ -0,0 +1,382 @@
+package loadbalance
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/alphadose/haxmap"
+)
+
+// shardInstance is a instance with the share of its weight held by a shard
+type shardInstance[T Hashable, I Instance[T]] struct {
+	instance I
+	weight   int
+}
+
+func (si shardInstance[T, I]) InstanceID() T {
+	return si.instance.InstanceID()
+}
+
+func (si shardInstance[T, I]) InstanceWeight() int {
+	return si.weight
+}
+
+// shardEntry is a instance added and the shares of its weight in every shard
+type shardEntry[T Hashable, I Instance[T]] struct {
+	instance I
+	shares   []int
+}
+
+// weightShard is a pair of queues (a `DynamicWeighted`) holding a share of the instances.
+// `total` is the sum of the shares, `left` is the number of selections
+// the shard still has in the round it is in, which is `round`.
+// The mutex of the shard guards the queues too, so selecting takes one lock.
+type weightShard[T Hashable, I Instance[T]] struct {
+	mutex sync.Mutex
+	dw    *DynamicWeighted[T, shardInstance[T, I]]
+	total int
+	left  int
+	round uint64
+}
+
+// take a selection of the round other than the excluded ones,
+// ok is false if the shard has used up its share of the round or has only excluded instances left in it,
+// the total of the shard is returned to tell a empty shard from a used up one.
+func (sh *weightShard[T, I]) take(round uint64, exclude []T) (ins I, ok bool, total int) {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	if sh.round < round {
+		// the first selection of a new round in this shard
+		sh.round = round
+		sh.left = sh.total
+		sh.dw.restart()
+	}
+	if sh.left <= 0 {
+		return ins, false, sh.total
+	}
+	var si shardInstance[T, I]
+	if len(exclude) == 0 {
+		si, ok = sh.dw.next(nil)
+		if !ok {
+			sh.left = 0
+		}
+	} else {
+		// never end the round of the shard, the other shards may still have the rest of it
+		si, ok = sh.dw.takeMain(exclude)
+	}
+	if !ok {
+		return ins, false, sh.total
+	}
+	sh.left--
+	return si.instance, true, sh.total
+}
+
+func (sh *weightShard[T, I]) add(instance I, share int) {
+	if share <= 0 {
+		return
+	}
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	sh.dw.Add(shardInstance[T, I]{instance: instance, weight: share})
+	sh.total += share
+	sh.left += share
+}
+
+func (sh *weightShard[T, I]) del(instance I, share int) {
+	if share <= 0 {
+		return
+	}
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	sh.dw.Del(shardInstance[T, I]{instance: instance})
+	sh.total -= share
+	if sh.left > sh.total {
+		sh.left = sh.total
+	}
+}
+
+func (sh *weightShard[T, I]) update(instance I, old, share int) {
+	if old <= 0 {
+		sh.add(instance, share)
+		return
+	}
+	if share <= 0 {
+		sh.del(instance, old)
+		return
+	}
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	sh.dw.Update(shardInstance[T, I]{instance: instance, weight: share})
+	sh.total += share - old
+	sh.left += share - old
+	if sh.left < 0 {
+		sh.left = 0
+	}
+}
+
+// shardHint is the shard a P starts from, which is `home` in a new round
+// and `cur` once `home` has used up the round.
+type shardHint struct {
+	home  int
+	cur   int
+	round uint64
+}
+
+// ShardedDynamicWeighted is a `DynamicWeighted` split into shards,
+// so that the `Select` running in parallel do not all wait for one mutex.
+//
+// Every shard is a pair of queues with its own mutex and holds a share of the weight of every instance:
+// weight/n each, and the remainder one by one to the shards following the hash of the instance id.
+// A caller is routed to a shard by a hint cached per P in a `sync.Pool`,
+// so the goroutines of a P keep to one shard and the Ps spread over the shards.
+//
+// The shards go through the rounds together: a shard that has used up its share of the round
+// sends the caller to the next shard, where the P stays till the end of the round,
+// and the next round starts when all of them have used it up.
+// So every round hands out every instance exactly its weight however the callers are spread,
+// while the order within a round depends on the shards the callers are routed to.
+//
+// Unlike `DynamicWeighted` the weight of a instance is read by `Add`, `Update` and `Replace` only.
+type ShardedDynamicWeighted[T Hashable, I Instance[T]] struct {
+	// mutex serializes the writers, `Select` never takes it
+	mutex   sync.Mutex
+	hashmap *haxmap.Map[T, *shardEntry[T, I]]
+	shards  atomic.Pointer[[]*weightShard[T, I]]
+	// round is the round the load-balance is in
+	round  uint64
+	hints  sync.Pool
+	next   uint32
+	idhash func(T) uint64
+	n      int
+}
+
+// NewShardedDynamicWeighted returns a ShardedDynamicWeighted of the given number of shards,
+// which is `runtime.GOMAXPROCS(0)` by default.
+func NewShardedDynamicWeighted[T Hashable, I Instance[T]](shards ...int) *ShardedDynamicWeighted[T, I] {
+	n := runtime.GOMAXPROCS(0)
+	if len(shards) > 0 && shards[0] > 0 {
+		n = shards[0]
+	}
+	sd := &ShardedDynamicWeighted[T, I]{
+		hashmap: haxmap.New[T, *shardEntry[T, I]](),
+		idhash:  GetHashFunc[T](),
+		n:       n,
+	}
+	sd.hints.New = func() any {
+		home := int(atomic.AddUint32(&sd.next, 1)-1) % sd.n
+		return &shardHint{home: home, cur: home}
+	}
+	s := sd.newShards()
+	sd.shards.Store(&s)
+	return sd
+}
+
+func (sd *ShardedDynamicWeighted[T, I]) newShards() []*weightShard[T, I] {
+	shards := make([]*weightShard[T, I], sd.n)
+	for i := range shards {
+		shards[i] = &weightShard[T, I]{dw: NewDynamicWeighted[T, shardInstance[T, I]]()}
+	}
+	return shards
+}
+
+// shares splits the weight of the instance into the shards
+func (sd *ShardedDynamicWeighted[T, I]) shares(instance I) []int {
+	w := instanceWeight[T](instance)
+	off := int(sd.idhash(instance.InstanceID()) % uint64(sd.n))
+	shares := make([]int, sd.n)
+	for s := range shares {
+		shares[s] = w / sd.n
+		if (s-off+sd.n)%sd.n < w%sd.n {
+			shares[s]++
+		}
+	}
+	return shares
+}
+
+// Add some instances and return the number of successful operation
+func (sd *ShardedDynamicWeighted[T, I]) Add(instances ...I) int {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+	shards := *sd.shards.Load()
+	count := 0
+	for _, instance := range instances {
+		instanceId := instance.InstanceID()
+		if _, ok := sd.hashmap.Get(instanceId); ok {
+			continue
+		}
+		e := &shardEntry[T, I]{instance: instance, shares: sd.shares(instance)}
+		for s, share := range e.shares {
+			shards[s].add(instance, share)
+		}
+		sd.hashmap.Set(instanceId, e)
+		count++
+	}
+	return count
+}
+
+// Del some instances and return the number of successful operation
+func (sd *ShardedDynamicWeighted[T, I]) DelByKey(keys ...T) int {
+	k := make([]I, 0)
+	for _, key := range keys {
+		ins, ok := sd.Get(key)
+		if ok {
+			k = append(k, ins)
+		}
+	}
+	return sd.Del(k...)
+}
+
+// Del some instances and return the number of successful operation
+func (sd *ShardedDynamicWeighted[T, I]) Del(instances ...I) int {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+	shards := *sd.shards.Load()
+	count := 0
+	for _, instance := range instances {
+		instanceId := instance.InstanceID()
+		e, ok := sd.hashmap.Get(instanceId)
+		if !ok {
+			continue
+		}
+		sd.hashmap.Del(instanceId)
+		for s, share := range e.shares {
+			shards[s].del(e.instance, share)
+		}
+		count++
+	}
+	return count
+}
+
+// Update the instances already added with their new weights
+// and return the number of successful operation,
+// every shard applies the new share of the instance like `DynamicWeighted.Update`.
+func (sd *ShardedDynamicWeighted[T, I]) Update(instances ...I) int {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+	shards := *sd.shards.Load()
+	count := 0
+	for _, instance := range instances {
+		instanceId := instance.InstanceID()
+		old, ok := sd.hashmap.Get(instanceId)
+		if !ok {
+			continue
+		}
+		e := &shardEntry[T, I]{instance: instance, shares: sd.shares(instance)}
+		for s, share := range e.shares {
+			shards[s].update(instance, old.shares[s], share)
+		}
+		sd.hashmap.Set(instanceId, e)
+		count++
+	}
+	return count
+}
+
+// Replace the instances with the new set in one step
+// and return the number of instances added or deleted.
+// The new shards are published at once and start a new round.
+func (sd *ShardedDynamicWeighted[T, I]) Replace(instances ...I) int {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+	added, kept, set := splitReplace(instances, func(id T) bool {
+		_, ok := sd.hashmap.Get(id)
+		return ok
+	})
+	var dels []T
+	sd.hashmap.ForEach(func(id T, _ *shardEntry[T, I]) bool {
+		if _, ok := set[id]; !ok {
+			dels = append(dels, id)
+		}
+		return true
+	})
+	shards := sd.newShards()
+	for _, list := range [][]I{kept, added} {
+		for _, instance := range list {
+			e := &shardEntry[T, I]{instance: instance, shares: sd.shares(instance)}
+			for s, share := range e.shares {
+				shards[s].add(instance, share)
+			}
+			sd.hashmap.Set(instance.InstanceID(), e)
+		}
+	}
+	for _, id := range dels {
+		sd.hashmap.Del(id)
+	}
+	sd.shards.Store(&shards)
+	return len(added) + len(dels)
+}
+
+func (sd *ShardedDynamicWeighted[T, I]) Size() int {
+	return int(sd.hashmap.Len())
+}
+
+// ForEach every instances. it is concurrency safe.
+func (sd *ShardedDynamicWeighted[T, I]) ForEach(callback func(T, I) bool) {
+	haxMapForEach(sd.hashmap, func(key T, e *shardEntry[T, I]) bool {
+		return callback(key, e.instance)
+	})
+}
+
+// Get the value corresponding to the key
+func (sd *ShardedDynamicWeighted[T, I]) Get(key T) (ins I, ok bool) {
+	if e, ok := haxMapGetVal(sd.hashmap, key); ok {
+		return e.instance, true
+	}
+	return
+}
+
+// Select a instance
+func (sd *ShardedDynamicWeighted[T, I]) Select() I {
+	ins, _ := sd.selectExcluding(nil)
+	return ins
+}
+
+// Pick selects a instance like `Select`, or returns `ErrNoInstances` if there is none
+func (sd *ShardedDynamicWeighted[T, I]) Pick(ctx context.Context) (I, error) {
+	return pick(ctx, func() (I, bool) { return sd.selectExcluding(nil) }, nil)
+}
+
+// SelectN selects at most n distinct instances by weight
+func (sd *ShardedDynamicWeighted[T, I]) SelectN(n int) []I {
+	return selectN[T, I](sd, n)
+}
+
+// SelectExcluding selects a instance other than the excluded ones,
+// it goes through the round like `Select` and skips the excluded instances,
+// a round whose rest is all excluded is ended at once.
+func (sd *ShardedDynamicWeighted[T, I]) SelectExcluding(exclude ...T) I {
+	ins, _ := sd.selectExcluding(exclude)
+	return ins
+}
+
+func (sd *ShardedDynamicWeighted[T, I]) selectExcluding(exclude []T) (ins I, ok bool) {
+	shards := *sd.shards.Load()
+	h := sd.hints.Get().(*shardHint)
+	defer sd.hints.Put(h)
+	for restarted := false; ; restarted = true {
+		round := atomic.LoadUint64(&sd.round)
+		if h.round != round {
+			h.round, h.cur = round, h.home
+		}
+		empty := true
+		for i := range shards {
+			s := (h.cur + i) % len(shards)
+			ins, ok, total := shards[s].take(round, exclude)
+			if ok {
+				// stay on the shard till it has used up the round
+				h.cur = s
+				return ins, true
+			}
+			if total > 0 {
+				empty = false
+			}
+		}
+		// there is no instance, or a whole new round has nothing but the excluded ones
+		if empty || (len(exclude) > 0 && restarted) {
+			return
+		}
+		// every shard has used up its share of the round, start the next one
+		atomic.CompareAndSwapUint64(&sd.round, round, round+1)
+	}
+}
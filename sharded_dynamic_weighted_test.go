@@ -0,0 +1,155 @@
+package loadbalance_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/ydmxcz/loadbalance"
+)
+
+func TestShardedDynamicWeightedRound(t *testing.T) {
+	sd := loadbalance.NewShardedDynamicWeighted[string, *myService](4)
+	ins := getInstance(1)
+	sd.Add(ins...)
+	// a single goroutine goes through the shards one by one,
+	// every round of 10 selections is exact
+	for round := 0; round < 5; round++ {
+		counts := make(map[string]int)
+		for i := 0; i < 10; i++ {
+			counts[sd.Select().Address]++
+		}
+		for _, in := range ins {
+			if counts[in.Address] != in.Memory {
+				t.Fatalf("round %d: %s was selected %d times, want %d: %v",
+					round, in.Address, counts[in.Address], in.Memory, counts)
+			}
+		}
+	}
+}
+
+func TestShardedDynamicWeightedParallel(t *testing.T) {
+	sd := loadbalance.NewShardedDynamicWeighted[string, *myService](4)
+	ins := getInstance(1)
+	sd.Add(ins...)
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	counts := make(map[string]int)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			local := make(map[string]int)
+			for i := 0; i < 2500; i++ {
+				local[sd.Select().Address]++
+			}
+			mutex.Lock()
+			for k, v := range local {
+				counts[k] += v
+			}
+			mutex.Unlock()
+		}()
+	}
+	wg.Wait()
+	// 20000 selections are 2000 full rounds whatever shards the goroutines were routed to
+	for _, in := range ins {
+		if counts[in.Address] != in.Memory*2000 {
+			t.Fatalf("%s was selected %d times, want %d: %v", in.Address, counts[in.Address], in.Memory*2000, counts)
+		}
+	}
+}
+
+func TestShardedDynamicWeightedUpdate(t *testing.T) {
+	sd := loadbalance.NewShardedDynamicWeighted[string, *myService](3)
+	ins := getInstance(1)
+	sd.Add(ins...)
+	if n := sd.Update(&myService{Address: ins[0].Address, Memory: 1},
+		&myService{Address: "unknown", Memory: 1}); n != 1 {
+		t.Fatalf("updated %d instances, want 1", n)
+	}
+	sd.Del(ins[2])
+	if sd.Size() != 2 {
+		t.Fatalf("%d instances, want 2", sd.Size())
+	}
+	// only the round changed on the way is off the new weights
+	counts := make(map[string]int)
+	for i := 0; i < 400; i++ {
+		counts[sd.Select().Address]++
+	}
+	if counts[ins[2].Address] != 0 {
+		t.Fatalf("selected a deleted instance for %d times", counts[ins[2].Address])
+	}
+	if c := counts[ins[0].Address]; c < 95 || c > 105 {
+		t.Fatalf("the instance of weight 1 was selected %d times, want about 100: %v", c, counts)
+	}
+}
+
+func TestShardedDynamicWeightedPick(t *testing.T) {
+	sw := loadbalance.NewShardedDynamicWeighted[string, *myService](4)
+	if _, err := sw.Pick(context.Background()); !errors.Is(err, loadbalance.ErrNoInstances) {
+		t.Fatalf("got %v, want ErrNoInstances", err)
+	}
+	ins := getInstance(1)
+	sw.Add(ins...)
+
+	// Pick goes through the exact rounds like `Select`
+	m := map[string]int{}
+	for i := 0; i < 100; i++ {
+		sel, err := sw.Pick(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		m[sel.Address]++
+	}
+	for _, in := range ins {
+		if m[in.Address] != in.Memory*10 {
+			t.Fatalf("%s was picked %d times, want %d: %v", in.Address, m[in.Address], in.Memory*10, m)
+		}
+	}
+}
+
+func TestShardedDynamicWeightedReplace(t *testing.T) {
+	lb := loadbalance.NewShardedDynamicWeighted[string, *myService](4)
+	ins := getInstance(1)
+	lb.Add(ins...)
+	updated := &myService{Address: ins[0].Address, Memory: 1}
+	if n := lb.Replace(updated, ins[2]); n != 1 || lb.Size() != 2 {
+		t.Fatalf("replaced %d instances, %d left", n, lb.Size())
+	}
+	m := map[string]int{}
+	for i := 0; i < 3000; i++ {
+		m[lb.Select().Address]++
+	}
+	if m[ins[1].Address] != 0 || math.Abs(float64(m[ins[0].Address])/3000-1.0/3) > 0.02 {
+		t.Fatalf("got %v after replace, want the weights 1,2", m)
+	}
+	checkReplaceAtomic(t, loadbalance.NewShardedDynamicWeighted[string, *myService](4))
+}
+
+func TestShardedDynamicWeightedSelectExcluding(t *testing.T) {
+	sw := loadbalance.NewShardedDynamicWeighted[string, *myService](4)
+	ins := getInstance(1)
+	sw.Add(ins...)
+
+	// every round hands out the rest their weights and ends when only the excluded one is left
+	m := map[string]int{}
+	for i := 0; i < 100; i++ {
+		m[sw.SelectExcluding(ins[0].Address).Address]++
+	}
+	if m[ins[0].Address] != 0 || m[ins[1].Address] != 60 || m[ins[2].Address] != 40 {
+		t.Fatalf("got %v when %s is excluded", m, ins[0].Address)
+	}
+	if sel := sw.SelectExcluding(ins[0].Address, ins[1].Address, ins[2].Address); sel != nil {
+		t.Fatalf("got %s when all are excluded", sel.Address)
+	}
+	// the round goes on without exclusion
+	m = map[string]int{}
+	for i := 0; i < 100; i++ {
+		m[sw.Select().Address]++
+	}
+	if m[ins[0].Address] != 50 || m[ins[1].Address] != 30 || m[ins[2].Address] != 20 {
+		t.Fatalf("got %v without exclusion", m)
+	}
+}
@@ -0,0 +1,197 @@
+package loadbalance
+
+import (
+	"context"
+	"sync"
+
+	"github.com/alphadose/haxmap"
+)
+
+// `swrrNode` is a instance with the weight it was added with
+// and the current weight of the smooth weighted round robin.
+type swrrNode[T Hashable, I Instance[T]] struct {
+	instance I
+	weight   int
+	current  int
+}
+
+// SmoothWeightedRoundRobin is the smooth weighted round robin of nginx.
+// On every `Select` the current weight of each instance grows by its weight,
+// the instance with the largest current weight is selected
+// and its current weight is reduced by the sum of all weights.
+// Weights 5,3,2 are spread as a,b,c,a,a,b,a,c,b,a rather than
+// sending consecutive picks to the same instance as `DynamicWeighted` does,
+// but select costs O(n) of the number of instance.
+//
+// The weight of a instance is read when it is added.
+type SmoothWeightedRoundRobin[T Hashable, I Instance[T]] struct {
+	mutex     sync.Mutex
+	hashmap   *haxmap.Map[T, *swrrNode[T, I]]
+	nodes     []*swrrNode[T, I]
+	weightSum int
+}
+
+func NewSmoothWeightedRoundRobin[T Hashable, I Instance[T]]() *SmoothWeightedRoundRobin[T, I] {
+	return &SmoothWeightedRoundRobin[T, I]{
+		hashmap: haxmap.New[T, *swrrNode[T, I]](8),
+		nodes:   make([]*swrrNode[T, I], 0, 8),
+	}
+}
+
+// Add some instances and return the number of successful operation
+func (sw *SmoothWeightedRoundRobin[T, I]) Add(instances ...I) int {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+	count := 0
+	for _, instance := range instances {
+		id := instance.InstanceID()
+		if _, ok := sw.hashmap.Get(id); !ok {
+			n := &swrrNode[T, I]{
+				instance: instance,
+				weight:   instanceWeight[T](instance),
+			}
+			sw.hashmap.Set(id, n)
+			sw.nodes = append(sw.nodes, n)
+			sw.weightSum += n.weight
+			count++
+		}
+	}
+	if count > 0 {
+		sw.reset()
+	}
+	return count
+}
+
+// Del some instances and return the number of successful operation
+func (sw *SmoothWeightedRoundRobin[T, I]) Del(instances ...I) int {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+	count := 0
+	for _, instance := range instances {
+		id := instance.InstanceID()
+		if _, ok := sw.hashmap.Get(id); ok {
+			for i := 0; i < len(sw.nodes); i++ {
+				if sw.nodes[i].instance.InstanceID() == id {
+					sw.hashmap.Del(id)
+					sw.weightSum -= sw.nodes[i].weight
+					sw.nodes = append(sw.nodes[:i], sw.nodes[i+1:]...)
+					break
+				}
+			}
+			count++
+		}
+	}
+	if count > 0 {
+		sw.reset()
+	}
+	return count
+}
+
+// Replace the instances with the new set in one step
+// and return the number of instances added or deleted,
+// the instances kept are updated with their new weight.
+func (sw *SmoothWeightedRoundRobin[T, I]) Replace(instances ...I) int {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+	newNode := func(instance I) *swrrNode[T, I] {
+		return &swrrNode[T, I]{
+			instance: instance,
+			weight:   instanceWeight[T](instance),
+		}
+	}
+	var count int
+	sw.nodes, count = replaceNodes(sw.hashmap, sw.nodes, instances,
+		func(n *swrrNode[T, I]) T { return n.instance.InstanceID() },
+		newNode,
+		func(_ *swrrNode[T, I], instance I) *swrrNode[T, I] { return newNode(instance) })
+	sw.weightSum = 0
+	for _, n := range sw.nodes {
+		sw.weightSum += n.weight
+	}
+	sw.reset()
+	return count
+}
+
+// reset the current weights so the sequence restarts
+// from the new set of instances instead of a skewed state
+func (sw *SmoothWeightedRoundRobin[T, I]) reset() {
+	for _, n := range sw.nodes {
+		n.current = 0
+	}
+}
+
+// Get the value corresponding to the key
+func (sw *SmoothWeightedRoundRobin[T, I]) Get(key T) (ins I, ok bool) {
+	if n, ok := haxMapGetVal(sw.hashmap, key); ok {
+		return n.instance, true
+	}
+	return
+}
+
+// ForEach every instances. it is concurrency safe.
+func (sw *SmoothWeightedRoundRobin[T, I]) ForEach(callback func(T, I) bool) {
+	haxMapForEach(sw.hashmap, func(key T, n *swrrNode[T, I]) bool {
+		return callback(key, n.instance)
+	})
+}
+
+func (sw *SmoothWeightedRoundRobin[T, I]) Size() int {
+	return int(sw.hashmap.Len())
+}
+
+// Select a instance
+func (sw *SmoothWeightedRoundRobin[T, I]) Select() (ins I) {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+	var best *swrrNode[T, I]
+	for _, n := range sw.nodes {
+		n.current += n.weight
+		if best == nil || n.current > best.current {
+			best = n
+		}
+	}
+	if best == nil {
+		return
+	}
+	best.current -= sw.weightSum
+	return best.instance
+}
+
+// Pick selects a instance like `Select`, or returns `ErrNoInstances` if there is none
+func (sw *SmoothWeightedRoundRobin[T, I]) Pick(ctx context.Context) (I, error) {
+	return pick(ctx, func() (I, bool) { return sw.selectExcluding(nil) }, nil)
+}
+
+// SelectN selects at most n distinct instances by weight
+func (sw *SmoothWeightedRoundRobin[T, I]) SelectN(n int) []I {
+	return selectN[T, I](sw, n)
+}
+
+// SelectExcluding selects a instance other than the excluded ones,
+// the excluded instances sit out this round as the tried peers of nginx do.
+func (sw *SmoothWeightedRoundRobin[T, I]) SelectExcluding(exclude ...T) I {
+	ins, _ := sw.selectExcluding(exclude)
+	return ins
+}
+
+func (sw *SmoothWeightedRoundRobin[T, I]) selectExcluding(exclude []T) (ins I, ok bool) {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+	var best *swrrNode[T, I]
+	total := 0
+	for _, n := range sw.nodes {
+		if excluded(exclude, n.instance.InstanceID()) {
+			continue
+		}
+		n.current += n.weight
+		total += n.weight
+		if best == nil || n.current > best.current {
+			best = n
+		}
+	}
+	if best == nil {
+		return
+	}
+	best.current -= total
+	return best.instance, true
+}
@@ -0,0 +1,90 @@
+package loadbalance_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ydmxcz/loadbalance"
+)
+
+func TestSmoothWeightedRoundRobinSequence(t *testing.T) {
+	lb := loadbalance.NewSmoothWeightedRoundRobin[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins...)
+	name := map[*myService]string{ins[0]: "a", ins[1]: "b", ins[2]: "c"}
+
+	seq := make([]string, 0, 20)
+	for i := 0; i < 20; i++ {
+		seq = append(seq, name[lb.Select()])
+	}
+	want := "abcaabacba" + "abcaabacba"
+	if got := strings.Join(seq, ""); got != want {
+		t.Fatalf("got sequence %s, want %s", got, want)
+	}
+
+	lb.Del(ins[0])
+	seq = seq[:0]
+	for i := 0; i < 5; i++ {
+		seq = append(seq, name[lb.Select()])
+	}
+	if got := strings.Join(seq, ""); got != "bcbcb" {
+		t.Fatalf("got sequence %s after delete, want bcbcb", got)
+	}
+}
+
+func TestSmoothWeightedRoundRobinSelectExcluding(t *testing.T) {
+	lb := loadbalance.NewSmoothWeightedRoundRobin[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins...)
+
+	// the rest go on with their own smooth sequence 3:2
+	m := map[string]int{}
+	for i := 0; i < 50; i++ {
+		m[lb.SelectExcluding(ins[0].Address).Address]++
+	}
+	if m[ins[0].Address] != 0 || m[ins[1].Address] != 30 || m[ins[2].Address] != 20 {
+		t.Fatalf("got %v when %s is excluded", m, ins[0].Address)
+	}
+	if sel := lb.SelectExcluding(ins[0].Address, ins[1].Address, ins[2].Address); sel != nil {
+		t.Fatalf("got %s when all are excluded", sel.Address)
+	}
+}
+
+func TestSmoothWeightedRoundRobinPick(t *testing.T) {
+	picker := loadbalance.NewSmoothWeightedRoundRobin[string, *myService]()
+	selector := loadbalance.NewSmoothWeightedRoundRobin[string, *myService]()
+	if _, err := picker.Pick(context.Background()); !errors.Is(err, loadbalance.ErrNoInstances) {
+		t.Fatalf("got %v, want ErrNoInstances", err)
+	}
+	ins := getInstance(1)
+	picker.Add(ins...)
+	selector.Add(ins...)
+
+	// Pick gives the same smooth sequence as `Select`
+	for i := 0; i < 20; i++ {
+		sel, err := picker.Pick(context.Background())
+		if want := selector.Select(); err != nil || sel != want {
+			t.Fatalf("picked %v, %v at %d, want %s", sel, err, i, want.Address)
+		}
+	}
+}
+
+func TestSmoothWeightedRoundRobinReplace(t *testing.T) {
+	lb := loadbalance.NewSmoothWeightedRoundRobin[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins...)
+	updated := &myService{Address: ins[0].Address, Memory: 1}
+	if n := lb.Replace(updated, ins[2]); n != 1 || lb.Size() != 2 {
+		t.Fatalf("replaced %d instances, %d left", n, lb.Size())
+	}
+	m := map[string]int{}
+	for i := 0; i < 300; i++ {
+		m[lb.Select().Address]++
+	}
+	if m[ins[1].Address] != 0 || m[ins[0].Address] != 100 || m[ins[2].Address] != 200 {
+		t.Fatalf("got %v after replace, want the weights 1,2", m)
+	}
+	checkReplaceAtomic(t, loadbalance.NewSmoothWeightedRoundRobin[string, *myService]())
+}
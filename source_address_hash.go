@@ -1,6 +1,7 @@
 package loadbalance
 
 import (
+	"context"
 	"sync"
 
 	"github.com/alphadose/haxmap"
@@ -38,7 +39,7 @@ func (kh *SourceAddressHash[T]) Del(instances ...Instance[T]) int {
 	count := 0
 	for _, instance := range instances {
 		id := instance.InstanceID()
-		if _, ok := kh.instanceMap.Get(id); !ok {
+		if _, ok := kh.instanceMap.Get(id); ok {
 			kh.rwmutex.Lock()
 
 			kh.delSlice(id)
@@ -67,15 +68,75 @@ func (kh *SourceAddressHash[T]) Add(instances ...Instance[T]) int {
 
 }
 
+// Replace the instances with the new set in one step
+// and return the number of instances added or deleted
+func (kh *SourceAddressHash[T]) Replace(instances ...Instance[T]) int {
+	kh.rwmutex.Lock()
+	defer kh.rwmutex.Unlock()
+	var count int
+	kh.insList, count = replaceInstances(kh.instanceMap, kh.insList, instances)
+	return count
+}
+
 func (kh *SourceAddressHash[T]) Get(key T) (Instance[T], bool) {
 	return haxMapGetVal(kh.instanceMap, key)
 }
 
 func (kh *SourceAddressHash[T]) SelectBy(key string) Instance[T] {
-	idx := kh.hashfunc(key) % uint64(kh.instanceMap.Len())
+	h := kh.hashfunc(key)
 	kh.rwmutex.RLock()
 	defer kh.rwmutex.RUnlock()
-	return kh.insList[idx]
+	// the list is the source of truth, the map may be updated before it
+	if len(kh.insList) == 0 {
+		return nil
+	}
+	return kh.insList[h%uint64(len(kh.insList))]
+}
+
+// PickBy selects a instance for the key like `SelectBy`, or returns `ErrNoInstances` if there is none
+func (kh *SourceAddressHash[T]) PickBy(ctx context.Context, key string) (Instance[T], error) {
+	return pick(ctx, func() (Instance[T], bool) { return kh.selectByExcluding(key, nil) }, nil)
+}
+
+// SelectByExcluding returns the instance the key is hashed to,
+// or the next one in the list which is not excluded.
+func (kh *SourceAddressHash[T]) SelectByExcluding(key string, exclude ...T) Instance[T] {
+	ins, _ := kh.selectByExcluding(key, exclude)
+	return ins
+}
+
+func (kh *SourceAddressHash[T]) selectByExcluding(key string, exclude []T) (Instance[T], bool) {
+	h := kh.hashfunc(key)
+	kh.rwmutex.RLock()
+	defer kh.rwmutex.RUnlock()
+	size := uint64(len(kh.insList))
+	for i := uint64(0); i < size; i++ {
+		ins := kh.insList[(h+i)%size]
+		if !excluded(exclude, ins.InstanceID()) {
+			return ins, true
+		}
+	}
+	return nil, false
+}
+
+// SelectNBy returns at most n distinct instances for the key,
+// the instance `SelectBy` returns and the ones following it in the list.
+func (kh *SourceAddressHash[T]) SelectNBy(key string, n int) []Instance[T] {
+	if n <= 0 {
+		return nil
+	}
+	h := kh.hashfunc(key)
+	kh.rwmutex.RLock()
+	defer kh.rwmutex.RUnlock()
+	size := uint64(len(kh.insList))
+	if uint64(n) > size {
+		n = int(size)
+	}
+	res := make([]Instance[T], n)
+	for i := range res {
+		res[i] = kh.insList[(h+uint64(i))%size]
+	}
+	return res
 }
 
 func (kh *SourceAddressHash[T]) Size() int {
@@ -0,0 +1,93 @@
+package loadbalance_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ydmxcz/loadbalance"
+)
+
+func TestSourceAddressHashSelectByExcluding(t *testing.T) {
+	lb := loadbalance.NewSourceAddressHash[string]()
+	ins := getInstance(1)
+	for _, in := range ins {
+		lb.Add(in)
+	}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("10.0.0.%d", i)
+		first := lb.SelectBy(key)
+		for _, gone := range ins {
+			sel := lb.SelectByExcluding(key, gone.Address)
+			// only the keys of the excluded instance move, always to the same one
+			if first.InstanceID() != gone.Address && sel != first {
+				t.Fatalf("key %s moved from %s to %v", key, first.InstanceID(), sel)
+			}
+			if sel == nil || sel.InstanceID() == gone.Address || sel != lb.SelectByExcluding(key, gone.Address) {
+				t.Fatalf("key %s went to %v without %s", key, sel, gone.Address)
+			}
+		}
+	}
+	if sel := lb.SelectByExcluding("key", ins[0].Address, ins[1].Address, ins[2].Address); sel != nil {
+		t.Fatalf("got %s when all are excluded", sel.InstanceID())
+	}
+}
+
+func TestSourceAddressHashSelectNBy(t *testing.T) {
+	lb := loadbalance.NewSourceAddressHash[string]()
+	ins := getInstance(1)
+	for _, in := range ins {
+		lb.Add(in)
+	}
+	// the replicas are the instance of the key and the ones following it in the list
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("10.0.0.%d", i)
+		res := lb.SelectNBy(key, 5)
+		checkDistinct(t, res, len(ins))
+		if res[0] != lb.SelectBy(key) || res[1] != lb.SelectByExcluding(key, res[0].InstanceID()) {
+			t.Fatalf("replicas of key %s are %v", key, res)
+		}
+	}
+}
+
+func TestSourceAddressHashPickBy(t *testing.T) {
+	lb := loadbalance.NewSourceAddressHash[string]()
+	// used to divide by zero
+	if lb.SelectBy("10.0.0.1") != nil {
+		t.Fatal("selected a instance from a empty load-balance")
+	}
+	if _, err := lb.PickBy(context.Background(), "10.0.0.1"); !errors.Is(err, loadbalance.ErrNoInstances) {
+		t.Fatalf("got %v, want ErrNoInstances", err)
+	}
+	ins := getInstance(1)
+	for _, in := range ins {
+		lb.Add(in)
+	}
+	if sel, err := lb.PickBy(context.Background(), "10.0.0.1"); err != nil || sel != lb.SelectBy("10.0.0.1") {
+		t.Fatalf("picked %v, %v", sel, err)
+	}
+	for _, in := range ins {
+		lb.Del(in)
+	}
+	if _, err := lb.PickBy(context.Background(), "10.0.0.1"); !errors.Is(err, loadbalance.ErrNoInstances) {
+		t.Fatalf("got %v after delete, want ErrNoInstances", err)
+	}
+}
+
+func TestSourceAddressHashReplace(t *testing.T) {
+	lb := loadbalance.NewSourceAddressHash[string]()
+	ins := getInstance(1)
+	lb.Add(ins[0], ins[1])
+	if n := lb.Replace(ins[1], ins[2]); n != 2 || lb.Size() != 2 {
+		t.Fatalf("replaced %d instances, %d left", n, lb.Size())
+	}
+	want := loadbalance.NewSourceAddressHash[string]()
+	want.Add(ins[1], ins[2])
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("10.0.0.%d", i)
+		if sel := lb.SelectBy(key); sel != want.SelectBy(key) {
+			t.Fatalf("key %s went to %v after replace", key, sel)
+		}
+	}
+}
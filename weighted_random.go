@@ -1,6 +1,7 @@
 package loadbalance
 
 import (
+	"context"
 	"sort"
 	"sync"
 	"time"
@@ -22,10 +23,33 @@ func (ig InstanceList[T, I]) Swap(i, j int) {
 	ig[i], ig[j] = ig[j], ig[i]
 }
 
+// weightedList sorts the instances together with their weights, the heaviest first
+type weightedList[T Hashable, I Instance[T]] struct {
+	instances InstanceList[T, I]
+	weights   []int64
+}
+
+func (wl weightedList[T, I]) Len() int {
+	return len(wl.instances)
+}
+
+func (wl weightedList[T, I]) Less(i, j int) bool {
+	return wl.weights[i] > wl.weights[j]
+}
+
+func (wl weightedList[T, I]) Swap(i, j int) {
+	wl.instances[i], wl.instances[j] = wl.instances[j], wl.instances[i]
+	wl.weights[i], wl.weights[j] = wl.weights[j], wl.weights[i]
+}
+
+// WeightedRandom selects a instance at random by weight.
+// The weight of a instance is read when it is added or updated by `Update`,
+// `weights` keeps it so that `weightSum` always equals the sum of them.
 type WeightedRandom[T Hashable, I Instance[T]] struct {
 	mutex        sync.Mutex
 	instancesMap *haxmap.Map[T, I]
 	instances    InstanceList[T, I]
+	weights      []int64
 	weightSum    int64
 	random       XorShift64
 	//random *rand.Rand
@@ -35,6 +59,7 @@ func NewWeightedRandom[T Hashable, I Instance[T]]() *WeightedRandom[T, I] {
 	return &WeightedRandom[T, I]{
 		instancesMap: haxmap.New[T, I](8),
 		instances:    make(InstanceList[T, I], 0, 8),
+		weights:      make([]int64, 0, 8),
 		//random:       rand.New(rand.NewSource(time.Now().UnixNano())),
 		random: XorShift64{
 			state: uint64(time.Now().UnixNano()),
@@ -50,13 +75,15 @@ func (wr *WeightedRandom[T, I]) Add(instances ...I) int {
 		id := instance.InstanceID()
 		if _, ok := wr.instancesMap.Get(id); !ok {
 			wr.instancesMap.Set(id, instance)
+			w := int64(instance.InstanceWeight())
 			wr.instances = append(wr.instances, instance)
-			wr.weightSum += int64(instance.InstanceWeight())
+			wr.weights = append(wr.weights, w)
+			wr.weightSum += w
 			count++
 		}
 	}
 	if count > 0 {
-		sort.Sort(wr.instances)
+		wr.sort()
 	}
 	return count
 }
@@ -64,13 +91,16 @@ func (wr *WeightedRandom[T, I]) Add(instances ...I) int {
 func (wr *WeightedRandom[T, I]) Select() (ins I) {
 	wr.mutex.Lock()
 	defer wr.mutex.Unlock()
+	if wr.weightSum <= 0 {
+		return
+	}
 	rdm := wr.random.Int63()%wr.weightSum + 1
 	//fmt.Println(rdm)
 	if rdm < 0 {
 		rdm = -rdm
 	}
 	for i := 0; i < len(wr.instances); i++ {
-		rdm -= int64(wr.instances[i].InstanceWeight())
+		rdm -= wr.weights[i]
 
 		if rdm <= 0 {
 			return wr.instances[i]
@@ -79,6 +109,47 @@ func (wr *WeightedRandom[T, I]) Select() (ins I) {
 	return
 }
 
+// Pick selects a instance like `Select`, or returns `ErrNoInstances` if there is none
+func (wr *WeightedRandom[T, I]) Pick(ctx context.Context) (I, error) {
+	return pick(ctx, func() (I, bool) { return wr.selectExcluding(nil) }, nil)
+}
+
+// SelectN selects at most n distinct instances at random by weight
+func (wr *WeightedRandom[T, I]) SelectN(n int) []I {
+	return selectN[T, I](wr, n)
+}
+
+// SelectExcluding selects a instance at random by weight other than the excluded ones
+func (wr *WeightedRandom[T, I]) SelectExcluding(exclude ...T) I {
+	ins, _ := wr.selectExcluding(exclude)
+	return ins
+}
+
+func (wr *WeightedRandom[T, I]) selectExcluding(exclude []T) (ins I, ok bool) {
+	wr.mutex.Lock()
+	defer wr.mutex.Unlock()
+	var sum int64
+	for i := 0; i < len(wr.instances); i++ {
+		if !excluded(exclude, wr.instances[i].InstanceID()) {
+			sum += wr.weights[i]
+		}
+	}
+	if sum <= 0 {
+		return
+	}
+	rdm := wr.random.Int63()%sum + 1
+	for i := 0; i < len(wr.instances); i++ {
+		if excluded(exclude, wr.instances[i].InstanceID()) {
+			continue
+		}
+		rdm -= wr.weights[i]
+		if rdm <= 0 {
+			return wr.instances[i], true
+		}
+	}
+	return
+}
+
 func (wr *WeightedRandom[T, I]) Get(key T) (I, bool) {
 	return haxMapGetVal(wr.instancesMap, key)
 }
@@ -95,20 +166,70 @@ func (wr *WeightedRandom[T, I]) Del(instances ...I) int {
 				if wr.instances[i].InstanceID() == id {
 					wr.instancesMap.Del(instance.InstanceID())
 					wr.instances = append(wr.instances[:i], wr.instances[i+1:]...)
-					wr.weightSum -= int64(instance.InstanceWeight())
+					wr.weightSum -= wr.weights[i]
+					wr.weights = append(wr.weights[:i], wr.weights[i+1:]...)
 					break
 				}
 			}
 			count++
 		}
 	}
+	return count
+}
 
+// Update the instances already added with their new weights
+// and return the number of successful operation,
+// the new weights apply to the selections after it returns.
+func (wr *WeightedRandom[T, I]) Update(instances ...I) int {
+	wr.mutex.Lock()
+	defer wr.mutex.Unlock()
+	count := 0
+	for _, instance := range instances {
+		id := instance.InstanceID()
+		if _, ok := wr.instancesMap.Get(id); !ok {
+			continue
+		}
+		for i := 0; i < len(wr.instances); i++ {
+			if wr.instances[i].InstanceID() == id {
+				w := int64(instance.InstanceWeight())
+				wr.instancesMap.Set(id, instance)
+				wr.instances[i] = instance
+				wr.weightSum += w - wr.weights[i]
+				wr.weights[i] = w
+				break
+			}
+		}
+		count++
+	}
 	if count > 0 {
-		sort.Sort(wr.instances)
+		wr.sort()
+	}
+	return count
+}
+
+// Replace the instances with the new set in one step
+// and return the number of instances added or deleted,
+// the instances kept are updated with their new weight.
+func (wr *WeightedRandom[T, I]) Replace(instances ...I) int {
+	wr.mutex.Lock()
+	defer wr.mutex.Unlock()
+	var count int
+	wr.instances, count = replaceInstances(wr.instancesMap, wr.instances, instances)
+	wr.weights = make([]int64, len(wr.instances))
+	wr.weightSum = 0
+	for i, instance := range wr.instances {
+		wr.weights[i] = int64(instance.InstanceWeight())
+		wr.weightSum += wr.weights[i]
 	}
+	wr.sort()
 	return count
 }
 
+// sort the instances by weight, the caller must hold the mutex.
+func (wr *WeightedRandom[T, I]) sort() {
+	sort.Sort(weightedList[T, I]{instances: wr.instances, weights: wr.weights})
+}
+
 func (wr *WeightedRandom[T, I]) ForEach(callback func(T, I) bool) {
 	haxMapForEach(wr.instancesMap, callback)
 }
@@ -0,0 +1,81 @@
+package loadbalance_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ydmxcz/loadbalance"
+)
+
+func TestWeightedRandomSelectExcluding(t *testing.T) {
+	lb := loadbalance.NewWeightedRandom[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins...)
+
+	// the rest keep their weights 3:2
+	m := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		m[lb.SelectExcluding(ins[0].Address).Address]++
+	}
+	if m[ins[0].Address] != 0 || m[ins[1].Address] < 5700 || m[ins[1].Address] > 6300 {
+		t.Fatalf("got %v when %s is excluded", m, ins[0].Address)
+	}
+	if sel := lb.SelectExcluding(ins[0].Address, ins[1].Address, ins[2].Address); sel != nil {
+		t.Fatalf("got %s when all are excluded", sel.Address)
+	}
+}
+
+func TestWeightedRandomSelectN(t *testing.T) {
+	checkLeftOut(t, loadbalance.NewWeightedRandom[string, *myService]())
+}
+
+func TestWeightedRandomReplace(t *testing.T) {
+	lb := loadbalance.NewWeightedRandom[string, *myService]()
+	ins := getInstance(1)
+	lb.Add(ins...)
+	// the weight of a kept instance is updated
+	updated := &myService{Address: ins[0].Address, Memory: 1}
+	if n := lb.Replace(updated, ins[2]); n != 1 || lb.Size() != 2 {
+		t.Fatalf("replaced %d instances, %d left", n, lb.Size())
+	}
+	m := map[string]int{}
+	for i := 0; i < 30000; i++ {
+		m[lb.Select().Address]++
+	}
+	if m[ins[1].Address] != 0 || math.Abs(float64(m[ins[0].Address])/30000-1.0/3) > 0.02 {
+		t.Fatalf("got %v after replace, want the weights 1,2", m)
+	}
+	checkReplaceAtomic(t, loadbalance.NewWeightedRandom[string, *myService]())
+}
+
+func TestWeightedRandomUpdate(t *testing.T) {
+	wr := loadbalance.NewWeightedRandom[string, *myService]()
+	ins := getInstance(1)
+	wr.Add(ins...)
+
+	if n := wr.Update(&myService{Address: ins[0].Address, Memory: 0},
+		&myService{Address: ins[2].Address, Memory: 9}); n != 2 {
+		t.Fatalf("updated %d instances, want 2", n)
+	}
+	// changing the weight without `Update` has no effect any more
+	ins[1].Memory = 100
+
+	const rounds = 100000
+	counts := make(map[string]int)
+	for i := 0; i < rounds; i++ {
+		counts[wr.Select().Address]++
+	}
+	if counts[ins[0].Address] != 0 {
+		t.Fatalf("selected a instance of weight 0 for %d times", counts[ins[0].Address])
+	}
+	if got := float64(counts[ins[2].Address]) / rounds; math.Abs(got-0.75) > 0.01 {
+		t.Fatalf("the share of weight 9 is %.3f, want 0.75", got)
+	}
+
+	wr.Del(ins[2])
+	for i := 0; i < 1000; i++ {
+		if sel := wr.Select(); sel == nil || sel.Address != ins[1].Address {
+			t.Fatalf("selected %v, want %s", sel, ins[1].Address)
+		}
+	}
+}